@@ -0,0 +1,520 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"iter"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// changesName is the name of the publication and the logical replication
+// slot used for the change feed.
+const changesName = "kvpostgres_changes"
+
+// changesPollInterval is the delay between polls for new changes when the
+// change feed is caught up.
+const changesPollInterval = 200 * time.Millisecond
+
+// changesBatchSize is the initial number of messages read from the
+// replication slot in a single poll.
+const changesBatchSize = 1000
+
+var errNoChangeFeed = fmt.Errorf("change feed is not enabled: %w", os.ErrInvalid)
+
+// LSN is a position in the postgres write-ahead log.
+type LSN uint64
+
+// String returns the LSN in the postgres text format.
+func (l LSN) String() string {
+	return fmt.Sprintf("%X/%X", uint32(l>>32), uint32(l))
+}
+
+// ParseLSN parses an LSN in the postgres text format.
+func ParseLSN(s string) (LSN, error) {
+	hi, lo, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, os.ErrInvalid
+	}
+	h, err := strconv.ParseUint(hi, 16, 32)
+	if err != nil {
+		return 0, err
+	}
+	l, err := strconv.ParseUint(lo, 16, 32)
+	if err != nil {
+		return 0, err
+	}
+	return LSN(h<<32 | l), nil
+}
+
+// ChangePosition is the position of a change in the change feed.
+type ChangePosition struct {
+	// LSN is the position of the commit that made the change.
+	LSN LSN
+
+	// Index is the position of the change among the changes of the commit.
+	Index int
+}
+
+// Change is a key-level change committed to the key-value store.
+type Change struct {
+	// LSN is the position of the commit record of the transaction that made
+	// the change. All changes from a transaction have the same LSN.
+	LSN LSN
+
+	// Index is the position of the change among the changes from the
+	// transaction.
+	Index int
+
+	// Revision is the commit revision of the change. It is zero for deletes.
+	Revision int64
+
+	Key string
+
+	// Value is the new value of the key. It is nil for deletes.
+	Value []byte
+
+	Deleted bool
+}
+
+// Position returns the position of the change in the change feed.
+func (c *Change) Position() ChangePosition {
+	return ChangePosition{LSN: c.LSN, Index: c.Index}
+}
+
+// after returns true if the position p is after the position q.
+func (p ChangePosition) after(q ChangePosition) bool {
+	return p.LSN > q.LSN || (p.LSN == q.LSN && p.Index > q.Index)
+}
+
+// Changes returns the changes after the given position in the commit order,
+// which is typically the position of the last change processed durably by
+// the caller; zero position returns all retained changes. Multiple changes to
+// a key in a transaction are reported as a single change. Iteration continues
+// till the context is canceled, in which case the context error is stored in
+// errp.
+//
+// Database must be opened with Options.ChangeFeed. All changes since the last
+// AckChanges call are retained by the database, so consumers should
+// acknowledge the processed changes periodically. Retained changes are read
+// again on every poll that finds new write-ahead log. Change feed supports a
+// single consumer.
+func (d *Database) Changes(ctx context.Context, from ChangePosition, errp *error) iter.Seq[*Change] {
+	return func(yield func(*Change) bool) {
+		if !d.changeFeed {
+			*errp = errNoChangeFeed
+			return
+		}
+		if from.LSN > 0 {
+			if err := d.AckChanges(ctx, from); err != nil {
+				*errp = err
+				return
+			}
+		}
+
+		pos := from
+		limit := changesBatchSize
+		var seen LSN
+		for {
+			var flushed string
+			if err := d.db.QueryRowContext(ctx, "SELECT pg_current_wal_flush_lsn()").Scan(&flushed); err != nil {
+				*errp = err
+				return
+			}
+			upto, err := ParseLSN(flushed)
+			if err != nil {
+				*errp = err
+				return
+			}
+
+			// Replication slot is read only when there is new write-ahead log
+			// since the last poll that has reached its end.
+			if upto > seen {
+				n, full, stop, err := d.peekChanges(ctx, pos, upto, limit, func(c *Change) bool {
+					pos = c.Position()
+					return yield(c)
+				})
+				if err != nil {
+					*errp = err
+					return
+				}
+				if stop {
+					return
+				}
+				if full {
+					// Batch holds only the changes that are already passed when
+					// the consumer does not acknowledge them, so it is enlarged to
+					// make progress.
+					if n == 0 {
+						limit *= 2
+					}
+					continue
+				}
+				seen = upto
+				if n > 0 {
+					continue
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				*errp = context.Cause(ctx)
+				return
+			case <-time.After(changesPollInterval):
+			}
+		}
+	}
+}
+
+// AckChanges informs the database that all changes up to and including the
+// given position are processed, so that they are not retained anymore.
+// Changes before the acknowledged position are not available to later
+// Changes calls. Transaction of the position is retained till a later
+// position is acknowledged, because it may have changes after the position.
+func (d *Database) AckChanges(ctx context.Context, pos ChangePosition) (status error) {
+	lsn := pos.LSN
+	ctx, span := d.startSpan(ctx, "kvpostgres.AckChanges", Attribute{Key: "kv.lsn", Value: lsn.String()})
+	defer func() { endSpan(span, status) }()
+
+	if !d.changeFeed {
+		return errNoChangeFeed
+	}
+	q := `SELECT pg_replication_slot_advance(slot_name, $2::pg_lsn) FROM pg_replication_slots WHERE slot_name = $1 AND confirmed_flush_lsn < $2::pg_lsn`
+	if _, err := d.db.ExecContext(ctx, q, changesName, lsn.String()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// setupChangeFeed creates the publication and the logical replication slot for
+// the change feed if they do not exist.
+func setupChangeFeed(ctx context.Context, db *sql.DB) error {
+	var walLevel string
+	if err := db.QueryRowContext(ctx, "SHOW wal_level").Scan(&walLevel); err != nil {
+		return err
+	}
+	if walLevel != "logical" {
+		return fmt.Errorf("change feed requires wal_level=logical (current: %s): %w", walLevel, os.ErrInvalid)
+	}
+
+	// Logical replication needs the full old rows to identify the keys
+	// because the table has no primary key. Full identity writes the old rows
+	// to the write-ahead log, so it is enabled only with the change feed.
+	var identity string
+	if err := db.QueryRowContext(ctx, "SELECT relreplident FROM pg_class WHERE oid = 'kv'::regclass").Scan(&identity); err != nil {
+		return err
+	}
+	if identity != "f" {
+		if _, err := db.ExecContext(ctx, "ALTER TABLE kv REPLICA IDENTITY FULL"); err != nil {
+			return err
+		}
+	}
+
+	var exists bool
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_publication WHERE pubname = $1)", changesName).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		if _, err := db.ExecContext(ctx, "CREATE PUBLICATION "+changesName+" FOR TABLE kv"); err != nil && !isDuplicateObject(err) {
+			return err
+		}
+	}
+
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_replication_slots WHERE slot_name = $1)", changesName).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		if _, err := db.ExecContext(ctx, "SELECT pg_create_logical_replication_slot($1, 'pgoutput')", changesName); err != nil && !isDuplicateObject(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func isDuplicateObject(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "42710"
+}
+
+// peekChanges reads the changes retained by the replication slot up to the
+// given LSN, in whole transactions of about limit messages, and passes the
+// changes after the given position to the callback. Returns the number of
+// changes passed, true if the read has stopped at the limit before the given
+// LSN and true if the callback has stopped the iteration.
+func (d *Database) peekChanges(ctx context.Context, from ChangePosition, upto LSN, limit int, fn func(*Change) bool) (n int, full, stop bool, status error) {
+	q := `SELECT data FROM pg_logical_slot_peek_binary_changes($1, $2::pg_lsn, $3, 'proto_version', '1', 'publication_names', $1)`
+	rows, err := d.db.QueryContext(ctx, q, changesName, upto.String(), limit)
+	if err != nil {
+		return 0, false, false, err
+	}
+	defer rows.Close()
+
+	nrows := 0
+	dec := &pgoutputDecoder{relations: make(map[uint32]*pgoutputRelation)}
+	for rows.Next() {
+		nrows++
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return n, false, false, err
+		}
+		changes, err := dec.decode(data)
+		if err != nil {
+			return n, false, false, err
+		}
+		for _, c := range changes {
+			if !c.Position().after(from) {
+				continue
+			}
+			if c.Value != nil {
+				if c.Value, err = d.decodeValue(ctx, c.Key, c.Value); err != nil {
+					return n, false, false, err
+				}
+			}
+			n++
+			if !fn(c) {
+				return n, false, true, nil
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return n, false, false, err
+	}
+	return n, nrows >= limit, false, nil
+}
+
+type pgoutputRelation struct {
+	name    string
+	columns []string
+}
+
+// pgoutputDecoder decodes the messages in the pgoutput logical replication
+// protocol (version 1) into key-level changes.
+type pgoutputDecoder struct {
+	relations map[uint32]*pgoutputRelation
+
+	// changes holds the changes in the current transaction in the order of
+	// first modification of each key.
+	changes []*Change
+	index   map[string]*Change
+}
+
+// decode decodes a single message and returns the changes from the
+// transaction when the message is a commit.
+func (v *pgoutputDecoder) decode(data []byte) ([]*Change, error) {
+	r := &pgoutputReader{buf: data}
+	switch kind := r.byte(); kind {
+	case 'B':
+		v.changes, v.index = nil, make(map[string]*Change)
+
+	case 'C':
+		r.byte() // Flags.
+		lsn := LSN(r.uint64())
+		r.uint64() // End LSN.
+		if r.err != nil {
+			return nil, r.err
+		}
+		changes := v.changes
+		for i, c := range changes {
+			c.LSN, c.Index = lsn, i
+		}
+		v.changes, v.index = nil, nil
+		return changes, nil
+
+	case 'R':
+		id := r.uint32()
+		r.string() // Namespace.
+		rel := &pgoutputRelation{name: r.string()}
+		r.byte() // Replica identity.
+		ncols := int(r.uint16())
+		for i := 0; i < ncols && r.err == nil; i++ {
+			r.byte() // Flags.
+			rel.columns = append(rel.columns, r.string())
+			r.uint32() // Type OID.
+			r.uint32() // Type modifier.
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		v.relations[id] = rel
+
+	case 'I', 'U', 'D':
+		rel, ok := v.relations[r.uint32()]
+		if !ok || r.err != nil {
+			return nil, fmt.Errorf("pgoutput message for unknown relation: %w", os.ErrInvalid)
+		}
+		var values map[string]*pgoutputValue
+		for r.err == nil && len(r.buf) > 0 {
+			switch tag := r.byte(); tag {
+			case 'K', 'O':
+				values = r.tuple(rel.columns)
+			case 'N':
+				// Unchanged TOASTed values (kind 'u') in the new tuple are taken
+				// from the old tuple, which is sent with the full replica
+				// identity.
+				old := values
+				values = r.tuple(rel.columns)
+				for col, v := range values {
+					if ov, ok := old[col]; ok && v.kind == 'u' {
+						values[col] = ov
+					}
+				}
+			default:
+				return nil, fmt.Errorf("unexpected pgoutput tuple tag %q: %w", tag, os.ErrInvalid)
+			}
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		if rel.name != "kv" {
+			return nil, nil
+		}
+		return nil, v.add(kind == 'D', values)
+
+	case 'O', 'Y', 'T', 'M':
+		// Origin, type, truncate and logical decoding messages are ignored.
+
+	default:
+		return nil, fmt.Errorf("unexpected pgoutput message type %q: %w", kind, os.ErrInvalid)
+	}
+	return nil, r.err
+}
+
+func (v *pgoutputDecoder) add(deleted bool, values map[string]*pgoutputValue) error {
+	keyValue, ok := values["key"]
+	if !ok || keyValue.kind != 't' {
+		return fmt.Errorf("pgoutput message without key: %w", os.ErrInvalid)
+	}
+	key, err := decodeBytea(keyValue.data)
+	if err != nil {
+		return err
+	}
+
+	c, ok := v.index[string(key)]
+	if !ok {
+		c = &Change{Key: string(key)}
+		v.index[c.Key] = c
+		v.changes = append(v.changes, c)
+	}
+	c.Deleted = deleted
+	if deleted {
+		c.Value, c.Revision = nil, 0
+		return nil
+	}
+
+	// Unchanged values (kind 'u') are kept from the earlier change to the key.
+	if value, ok := values["value"]; ok {
+		switch value.kind {
+		case 't':
+			if c.Value, err = decodeBytea(value.data); err != nil {
+				return err
+			}
+		case 'n':
+			c.Value = nil
+		}
+	}
+	if revision, ok := values["revision"]; ok && revision.kind == 't' {
+		if c.Revision, err = strconv.ParseInt(string(revision.data), 10, 64); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeBytea decodes a bytea value in the hex text format.
+func decodeBytea(s []byte) ([]byte, error) {
+	if len(s) < 2 || s[0] != '\\' || s[1] != 'x' {
+		return nil, fmt.Errorf("unexpected bytea text format: %w", os.ErrInvalid)
+	}
+	return hex.DecodeString(string(s[2:]))
+}
+
+type pgoutputValue struct {
+	kind byte
+	data []byte
+}
+
+type pgoutputReader struct {
+	buf []byte
+	err error
+}
+
+func (r *pgoutputReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.buf) < n {
+		r.err = fmt.Errorf("truncated pgoutput message: %w", os.ErrInvalid)
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *pgoutputReader) byte() byte {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *pgoutputReader) uint16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *pgoutputReader) uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *pgoutputReader) uint64() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *pgoutputReader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	for i, c := range r.buf {
+		if c == 0 {
+			s := string(r.buf[:i])
+			r.buf = r.buf[i+1:]
+			return s
+		}
+	}
+	r.err = fmt.Errorf("unterminated string in pgoutput message: %w", os.ErrInvalid)
+	return ""
+}
+
+func (r *pgoutputReader) tuple(columns []string) map[string]*pgoutputValue {
+	ncols := int(r.uint16())
+	values := make(map[string]*pgoutputValue, ncols)
+	for i := 0; i < ncols && r.err == nil; i++ {
+		v := &pgoutputValue{kind: r.byte()}
+		if v.kind == 't' || v.kind == 'b' {
+			v.data = r.next(int(r.uint32()))
+		}
+		if i < len(columns) {
+			values[columns[i]] = v
+		}
+	}
+	return values
+}
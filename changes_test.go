@@ -0,0 +1,235 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLSN(t *testing.T) {
+	for _, s := range []string{"0/0", "16/B374D848", "FFFFFFFF/FFFFFFFF"} {
+		lsn, err := ParseLSN(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lsn.String() != s {
+			t.Errorf("wanted %s, got %s", s, lsn)
+		}
+	}
+	if _, err := ParseLSN("16"); err == nil {
+		t.Errorf("wanted error for invalid lsn")
+	}
+}
+
+type pgoutputWriter struct {
+	bytes.Buffer
+}
+
+func (w *pgoutputWriter) u16(v uint16) { binary.Write(w, binary.BigEndian, v) }
+func (w *pgoutputWriter) u32(v uint32) { binary.Write(w, binary.BigEndian, v) }
+func (w *pgoutputWriter) u64(v uint64) { binary.Write(w, binary.BigEndian, v) }
+func (w *pgoutputWriter) str(s string) { w.WriteString(s); w.WriteByte(0) }
+
+func (w *pgoutputWriter) text(s string) {
+	w.WriteByte('t')
+	w.u32(uint32(len(s)))
+	w.WriteString(s)
+}
+
+func bytea(s string) string {
+	return `\x` + hex.EncodeToString([]byte(s))
+}
+
+func TestPgoutputDecoder(t *testing.T) {
+	var msgs [][]byte
+	add := func(fn func(w *pgoutputWriter)) {
+		w := new(pgoutputWriter)
+		fn(w)
+		msgs = append(msgs, w.Bytes())
+	}
+
+	add(func(w *pgoutputWriter) {
+		w.WriteByte('B')
+		w.u64(100)
+		w.u64(0)
+		w.u32(1)
+	})
+	add(func(w *pgoutputWriter) {
+		w.WriteByte('R')
+		w.u32(42)
+		w.str("public")
+		w.str("kv")
+		w.WriteByte('d')
+		w.u16(3)
+		for _, col := range []string{"key", "value", "revision"} {
+			w.WriteByte(0)
+			w.str(col)
+			w.u32(17)
+			w.u32(0xFFFFFFFF)
+		}
+	})
+	add(func(w *pgoutputWriter) {
+		w.WriteByte('I')
+		w.u32(42)
+		w.WriteByte('N')
+		w.u16(3)
+		w.text(bytea("/a"))
+		w.text(bytea("one"))
+		w.WriteByte('n')
+	})
+	// Revision stamp leaves the value unchanged.
+	add(func(w *pgoutputWriter) {
+		w.WriteByte('U')
+		w.u32(42)
+		w.WriteByte('N')
+		w.u16(3)
+		w.text(bytea("/a"))
+		w.WriteByte('u')
+		w.text("7")
+	})
+	add(func(w *pgoutputWriter) {
+		w.WriteByte('D')
+		w.u32(42)
+		w.WriteByte('K')
+		w.u16(3)
+		w.text(bytea("/b"))
+		w.WriteByte('n')
+		w.WriteByte('n')
+	})
+	// Update of an unchanged TOASTed value carries it in the old tuple.
+	add(func(w *pgoutputWriter) {
+		w.WriteByte('U')
+		w.u32(42)
+		w.WriteByte('O')
+		w.u16(3)
+		w.text(bytea("/c"))
+		w.text(bytea("large"))
+		w.text("5")
+		w.WriteByte('N')
+		w.u16(3)
+		w.text(bytea("/c"))
+		w.WriteByte('u')
+		w.text("8")
+	})
+	add(func(w *pgoutputWriter) {
+		w.WriteByte('C')
+		w.WriteByte(0)
+		w.u64(100)
+		w.u64(120)
+		w.u64(0)
+	})
+
+	dec := &pgoutputDecoder{relations: make(map[uint32]*pgoutputRelation)}
+	var changes []*Change
+	for _, msg := range msgs {
+		cs, err := dec.decode(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		changes = append(changes, cs...)
+	}
+
+	if len(changes) != 3 {
+		t.Fatalf("wanted 3 changes, got %d", len(changes))
+	}
+	if c := changes[0]; c.Key != "/a" || string(c.Value) != "one" || c.Revision != 7 || c.Deleted || c.Position() != (ChangePosition{100, 0}) {
+		t.Errorf("unexpected change %+v", c)
+	}
+	if c := changes[1]; c.Key != "/b" || !c.Deleted || c.Position() != (ChangePosition{100, 1}) {
+		t.Errorf("unexpected change %+v", c)
+	}
+	if c := changes[2]; c.Key != "/c" || string(c.Value) != "large" || c.Revision != 8 || c.Deleted || c.Position() != (ChangePosition{100, 2}) {
+		t.Errorf("unexpected change %+v", c)
+	}
+}
+
+func TestChanges(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	db, err := NewWithOptions(ctx, dbDir, &Options{ChangeFeed: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var identity string
+	if err := db.db.QueryRowContext(ctx, "SELECT relreplident FROM pg_class WHERE oid = 'kv'::regclass").Scan(&identity); err != nil {
+		t.Fatal(err)
+	}
+	if identity != "f" {
+		t.Errorf("got replica identity %q, want full identity with the change feed", identity)
+	}
+
+	for _, key := range []string{"/a", "/b"} {
+		tx, err := db.NewTransaction(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Set(ctx, key, strings.NewReader(key)); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	collect := func(from ChangePosition, n int) []*Change {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		var changes []*Change
+		for c := range db.Changes(ctx, from, &err) {
+			changes = append(changes, c)
+			if len(changes) == n {
+				break
+			}
+		}
+		return changes
+	}
+
+	changes := collect(ChangePosition{}, 2)
+	if len(changes) != 2 || changes[0].Key != "/a" || changes[1].Key != "/b" || string(changes[1].Value) != "/b" {
+		t.Fatalf("unexpected changes %+v (%v)", changes, err)
+	}
+	if changes[0].LSN >= changes[1].LSN {
+		t.Fatalf("wanted changes in the commit order")
+	}
+
+	// Resume after the first change.
+	changes = collect(changes[0].Position(), 1)
+	if len(changes) != 1 || changes[0].Key != "/b" {
+		t.Fatalf("unexpected changes after resume %+v (%v)", changes, err)
+	}
+
+	// Resume in the middle of a transaction.
+	tx, err := db.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"/c", "/d", "/e"} {
+		if err := tx.Set(ctx, key, strings.NewReader(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	changes = collect(changes[0].Position(), 2)
+	if len(changes) != 2 || changes[0].Key != "/c" || changes[1].LSN != changes[0].LSN {
+		t.Fatalf("unexpected changes from the transaction %+v (%v)", changes, err)
+	}
+	changes = collect(changes[0].Position(), 2)
+	if len(changes) != 2 || changes[0].Key != "/d" || changes[1].Key != "/e" {
+		t.Fatalf("unexpected changes after resume in the transaction %+v (%v)", changes, err)
+	}
+}
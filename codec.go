@@ -0,0 +1,199 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// valueMagic is the prefix of the encoded values in the database. Encoded
+// values have a one byte codec id after the magic. Values that are stored
+// without encoding, but begin with the magic, are stored with the plainCodec
+// id, so that all values are decoded unambiguously.
+const valueMagic = "\xffKV"
+
+// plainCodec is the codec id for the values stored as is.
+const plainCodec = 0
+
+// encryptedCodec is the codec id for the encrypted values. See encrypt.go.
+const encryptedCodec = 2
+
+// Codec compresses the values stored in the database.
+type Codec interface {
+	// ID returns a unique identifier for the codec, which is stored with the
+	// encoded values. Identifiers 0-15 are reserved for the codecs in this
+	// package, so databases configured with other codecs using them fail to
+	// open.
+	ID() byte
+
+	Encode(src []byte) ([]byte, error)
+	Decode(src []byte) ([]byte, error)
+}
+
+// Compression configures compression of the values for a key prefix.
+type Compression struct {
+	// Prefix selects the keys compressed as per this setting. When multiple
+	// prefixes match a key, longest prefix is used. Empty prefix matches all
+	// keys.
+	Prefix string
+
+	// Codec compresses the values. Nil disables compression for the prefix.
+	Codec Codec
+
+	// MinSize is the minimum size of the values that are compressed.
+	MinSize int
+}
+
+// GzipCodec compresses the values with gzip.
+type GzipCodec struct {
+	// Level is the gzip compression level. Zero value uses the default level.
+	Level int
+}
+
+// ID implements the Codec interface.
+func (GzipCodec) ID() byte {
+	return 1
+}
+
+// Encode implements the Codec interface.
+func (c GzipCodec) Encode(src []byte) ([]byte, error) {
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode implements the Codec interface.
+func (GzipCodec) Decode(src []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// checkCodecs verifies that the configured codecs do not use the reserved
+// identifiers, except for the codecs in this package.
+func checkCodecs(compression []Compression, codecs []Codec) error {
+	for _, c := range compression {
+		if c.Codec != nil {
+			codecs = append(codecs, c.Codec)
+		}
+	}
+	for _, c := range codecs {
+		switch c.(type) {
+		case GzipCodec, *GzipCodec:
+			continue
+		}
+		if id := c.ID(); id < 16 {
+			return fmt.Errorf("codec id %d is reserved: %w", id, os.ErrInvalid)
+		}
+	}
+	return nil
+}
+
+// setCodecs registers the codecs used to encode and decode the values.
+func (d *Database) setCodecs(compression []Compression, codecs []Codec) {
+	d.codecs = map[byte]Codec{
+		GzipCodec{}.ID(): GzipCodec{},
+	}
+	for _, c := range codecs {
+		d.codecs[c.ID()] = c
+	}
+	for _, c := range compression {
+		if c.Codec != nil {
+			d.codecs[c.Codec.ID()] = c.Codec
+		}
+	}
+	d.compression = compression
+}
+
+// encodeValue returns the value to be stored in the database for a key.
+func (d *Database) encodeValue(ctx context.Context, k string, value []byte) ([]byte, error) {
+	data, err := d.compressValue(k, value)
+	if err != nil {
+		return nil, err
+	}
+	if d.keys == nil {
+		return data, nil
+	}
+	return d.encrypt(ctx, k, data)
+}
+
+// compressValue returns the value compressed as per the compression settings
+// for the key.
+func (d *Database) compressValue(k string, value []byte) ([]byte, error) {
+	var rule *Compression
+	for i, c := range d.compression {
+		if strings.HasPrefix(k, c.Prefix) && (rule == nil || len(c.Prefix) > len(rule.Prefix)) {
+			rule = &d.compression[i]
+		}
+	}
+	if rule != nil && rule.Codec != nil && len(value) >= rule.MinSize {
+		data, err := rule.Codec.Encode(value)
+		if err != nil {
+			return nil, err
+		}
+		// Compressed value is used only when it is smaller.
+		if len(valueMagic)+1+len(data) < len(value) {
+			return appendHeader(rule.Codec.ID(), data), nil
+		}
+	}
+	if strings.HasPrefix(string(value), valueMagic) {
+		return appendHeader(plainCodec, value), nil
+	}
+	return value, nil
+}
+
+// decodeValue returns the original value for a value stored in the database
+// for a key.
+func (d *Database) decodeValue(ctx context.Context, k string, data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(valueMagic)) {
+		return data, nil
+	}
+	if len(data) < len(valueMagic)+1 {
+		return nil, fmt.Errorf("stored value has a truncated header: %w", os.ErrInvalid)
+	}
+	id, payload := data[len(valueMagic)], data[len(valueMagic)+1:]
+	if id == plainCodec {
+		return payload, nil
+	}
+	if id == encryptedCodec {
+		plain, err := d.decrypt(ctx, k, payload)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeValue(ctx, k, plain)
+	}
+	codec, ok := d.codecs[id]
+	if !ok {
+		return nil, fmt.Errorf("stored value uses unknown codec %d: %w", id, os.ErrInvalid)
+	}
+	return codec.Decode(payload)
+}
+
+func appendHeader(id byte, data []byte) []byte {
+	v := make([]byte, 0, len(valueMagic)+1+len(data))
+	v = append(v, valueMagic...)
+	v = append(v, id)
+	return append(v, data...)
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncodeValue(t *testing.T) {
+	ctx := context.Background()
+
+	d := new(Database)
+	d.setCodecs([]Compression{
+		{Prefix: "", Codec: GzipCodec{}, MinSize: 64},
+		{Prefix: "/raw/", Codec: nil},
+	}, nil)
+
+	large := []byte(strings.Repeat("compressible ", 100))
+	tests := []struct {
+		key        string
+		value      []byte
+		compressed bool
+	}{
+		{"/a", large, true},
+		{"/a", []byte("small"), false},
+		{"/raw/a", large, false},
+		{"/raw/a", []byte(valueMagic + "looks like a header"), false},
+		{"/a", []byte{}, false},
+	}
+	for i, test := range tests {
+		data, err := d.encodeValue(ctx, test.key, test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if compressed := len(data) < len(test.value); compressed != test.compressed {
+			t.Errorf("%d: wanted compressed=%t, got %t", i, test.compressed, compressed)
+		}
+		value, err := d.decodeValue(ctx, test.key, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(value, test.value) {
+			t.Errorf("%d: value did not round trip", i)
+		}
+	}
+
+	if _, err := d.decodeValue(ctx, "/a", []byte(valueMagic+"\x0e")); err == nil {
+		t.Errorf("wanted error for unknown codec")
+	}
+}
+
+type testCodec byte
+
+func (c testCodec) ID() byte                        { return byte(c) }
+func (testCodec) Encode(src []byte) ([]byte, error) { return src, nil }
+func (testCodec) Decode(src []byte) ([]byte, error) { return src, nil }
+
+func TestCheckCodecs(t *testing.T) {
+	for _, test := range []struct {
+		codec Codec
+		ok    bool
+	}{
+		{GzipCodec{}, true},
+		{&GzipCodec{Level: 9}, true},
+		{testCodec(16), true},
+		{testCodec(plainCodec), false},
+		{testCodec(GzipCodec{}.ID()), false},
+		{testCodec(encryptedCodec), false},
+		{testCodec(15), false},
+	} {
+		if err := checkCodecs(nil, []Codec{test.codec}); (err == nil) != test.ok {
+			t.Errorf("codec %v: got %v, want ok=%t", test.codec, err, test.ok)
+		}
+		err := checkCodecs([]Compression{{Prefix: "/a/", Codec: test.codec}}, nil)
+		if (err == nil) != test.ok || (err != nil && !errors.Is(err, os.ErrInvalid)) {
+			t.Errorf("compression codec %v: got %v, want ok=%t", test.codec, err, test.ok)
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	opts := &Options{
+		Compression: []Compression{{Prefix: "/json/", Codec: GzipCodec{}, MinSize: 128}},
+	}
+	db, err := NewWithOptions(ctx, dbDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	value := strings.Repeat(`{"name": "value"}`, 100)
+	tx, err := db.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Set(ctx, "/json/1", strings.NewReader(value)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var size int
+	if err := db.db.QueryRowContext(ctx, "SELECT octet_length(value) FROM kv WHERE key = $1", "/json/1").Scan(&size); err != nil {
+		t.Fatal(err)
+	}
+	if size >= len(value) {
+		t.Fatalf("wanted compressed value size less than %d, got %d", len(value), size)
+	}
+
+	snap, err := db.NewSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Discard(ctx)
+
+	for k, v := range snap.Ascend(ctx, "", "", &err) {
+		data, err := io.ReadAll(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != value {
+			t.Fatalf("value of %s did not round trip", k)
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/lib/pq"
+)
+
+// DeleteRange removes all key-value pairs in the given range and returns the
+// number of keys removed. Range semantics and validation are same as Ascend.
+func (t *Transaction) DeleteRange(ctx context.Context, beg, end string) (_ int64, status error) {
+	ctx, span := t.db.startSpan(ctx, "kvpostgres.DeleteRange", Attribute{Key: AttrBegin, Value: beg}, Attribute{Key: AttrEnd, Value: end})
+	defer func() { endSpan(span, status) }()
+
+	if t.tx == nil {
+		return 0, os.ErrClosed
+	}
+	if beg > end && end != "" {
+		return 0, os.ErrInvalid
+	}
+	return t.deleteRange(ctx, beg, end, 0)
+}
+
+// DeletePrefix removes all key-value pairs with the given key prefix and
+// returns the number of keys removed.
+func (t *Transaction) DeletePrefix(ctx context.Context, prefix string) (int64, error) {
+	if len(prefix) == 0 {
+		return 0, os.ErrInvalid
+	}
+	return t.DeleteRange(ctx, prefix, prefixEnd(prefix))
+}
+
+// DeleteRangeBatched is similar to Transaction.DeleteRange, but removes the keys in
+// batches of up to batchSize keys, each in a separate transaction, so that
+// removing a huge range doesn't hold the locks for a long time. Deletes are
+// not atomic: keys removed by the committed batches stay removed when a later
+// batch fails, so it is safe to retry the call on errors.
+func (d *Database) DeleteRangeBatched(ctx context.Context, beg, end string, batchSize int) (_ int64, status error) {
+	ctx, span := d.startSpan(ctx, "kvpostgres.DeleteRangeBatched", Attribute{Key: AttrBegin, Value: beg}, Attribute{Key: AttrEnd, Value: end})
+	defer func() { endSpan(span, status) }()
+
+	if batchSize <= 0 {
+		return 0, os.ErrInvalid
+	}
+	if beg > end && end != "" {
+		return 0, os.ErrInvalid
+	}
+
+	var total int64
+	for {
+		n, err := d.deleteBatch(ctx, beg, end, batchSize)
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
+
+func (d *Database) deleteBatch(ctx context.Context, beg, end string, batchSize int) (int64, error) {
+	tx, err := d.NewTransaction(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	n, err := tx.deleteRange(ctx, beg, end, batchSize)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// deleteRange removes up to limit keys in the given range in the key order,
+// or all keys in the range if limit is zero, with a single statement.
+func (t *Transaction) deleteRange(ctx context.Context, beg, end string, limit int) (int64, error) {
+	cond, args := keyRange(beg, end, 1)
+	q := "DELETE FROM kv WHERE " + cond + " RETURNING key, value"
+	if limit > 0 {
+		q = fmt.Sprintf("DELETE FROM kv WHERE ctid IN (SELECT ctid FROM kv WHERE %s ORDER BY %s LIMIT %d) RETURNING key, value", cond, keyOrder("ASC"), limit)
+	}
+	rows, err := t.tx.QueryContext(ctx, q, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	// Deleted values are recorded as the baseline versions of the keys that
+	// are deleted before their first write in the versioned mode.
+	var keys, bkeys, bvalues [][]byte
+	for rows.Next() {
+		var key, value []byte
+		if err := rows.Scan(&key, &value); err != nil {
+			return 0, err
+		}
+		keys = append(keys, key)
+		if _, ok := t.writes[string(key)]; !ok {
+			bkeys, bvalues = append(bkeys, key), append(bvalues, value)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	if t.db.versioned && len(bkeys) > 0 {
+		q := `INSERT INTO kv_history (key, revision, committed_at, value, deleted) SELECT key, 0, '-infinity', value, FALSE FROM unnest($1::bytea[], $2::bytea[]) AS b(key, value) WHERE NOT EXISTS (SELECT 1 FROM kv_history h WHERE h.key = b.key)`
+		if _, err := t.tx.ExecContext(ctx, q, pq.ByteaArray(bkeys), pq.ByteaArray(bvalues)); err != nil {
+			return 0, err
+		}
+	}
+
+	t.db.mu.Lock()
+	nindexes := len(t.db.indexes)
+	t.db.mu.Unlock()
+
+	if nindexes > 0 {
+		if _, err := t.tx.ExecContext(ctx, "DELETE FROM kv_index WHERE key = ANY($1)", pq.ByteaArray(keys)); err != nil {
+			return 0, err
+		}
+	}
+	for _, k := range keys {
+		t.setWrite(string(k), false)
+	}
+	return int64(len(keys)), nil
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDeleteRange(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	db, err := NewWithOptions(ctx, dbDir, &Options{Versioned: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	for _, p := range []string{"/a/", "/b/", "/c/"} {
+		for i := 0; i < 10; i++ {
+			if err := tx.Set(ctx, fmt.Sprintf("%s%02d", p, i), strings.NewReader("v")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err = db.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.DeleteRange(ctx, "/b", "/a"); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("wanted os.ErrInvalid for an invalid range, got %v", err)
+	}
+	if _, err := tx.DeletePrefix(ctx, ""); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("wanted os.ErrInvalid for an empty prefix, got %v", err)
+	}
+	if n, err := tx.DeletePrefix(ctx, "/a/"); err != nil || n != 10 {
+		t.Fatalf("got %d, %v, want 10 keys deleted", n, err)
+	}
+	if n, err := tx.DeleteRange(ctx, "/b/05", "/c/"); err != nil || n != 5 {
+		t.Fatalf("got %d, %v, want 5 keys deleted", n, err)
+	}
+	if _, err := tx.Get(ctx, "/a/00"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("wanted os.ErrNotExist for a deleted key, got %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if tx.CommitRevision() == 0 {
+		t.Errorf("wanted a commit revision for range deletes")
+	}
+
+	// Range deletes are recorded in the history.
+	snap, err := db.NewSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions, err := snap.History(ctx, "/a/03")
+	snap.Discard(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || !versions[0].Deleted {
+		t.Errorf("wanted a delete version in the history, got %+v", versions)
+	}
+
+	if n, err := db.DeleteRangeBatched(ctx, "", "", 3); err != nil || n != 15 {
+		t.Fatalf("got %d, %v, want 15 keys deleted", n, err)
+	}
+
+	snap, err = db.NewSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Discard(ctx)
+
+	for k := range snap.Ascend(ctx, "", "", &err) {
+		t.Errorf("unexpected key %q after deleting all keys", k)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// ElectionKeyPrefix is the key prefix of the keys that hold the current
+// leaders of the elections. Key for an election is the prefix followed by
+// the election name and its value is the leader's candidate id.
+const ElectionKeyPrefix = "/kvpostgres/elections/"
+
+// Leadership represents the leadership of a candidate in an election, which
+// is held through a session-level advisory lock. See Database.Campaign.
+type Leadership struct {
+	lock      *Lock
+	election  string
+	candidate string
+}
+
+// Campaign blocks till the candidate becomes the leader of the election or
+// the context is canceled. Leader's candidate id is recorded in the
+// key-value store, so that other processes can find the current leader. See
+// Database.Leader.
+//
+// Leadership is lost when the lock connection is lost or the database is
+// closed, which is signaled through Leadership.Lost; leaders must stop
+// acting as leaders when it is signaled.
+func (d *Database) Campaign(ctx context.Context, election, candidate string) (_ *Leadership, status error) {
+	ctx, span := d.startSpan(ctx, "kvpostgres.Campaign", Attribute{Key: AttrKey, Value: election})
+	defer func() { endSpan(span, status) }()
+
+	if len(election) == 0 || len(candidate) == 0 {
+		return nil, os.ErrInvalid
+	}
+
+	lock, err := d.Lock(ctx, electionLockName(election))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if status != nil {
+			lock.Unlock(context.Background())
+		}
+	}()
+
+	if err := d.setLeader(ctx, election, candidate); err != nil {
+		return nil, err
+	}
+	le := &Leadership{
+		lock:      lock,
+		election:  election,
+		candidate: candidate,
+	}
+	return le, nil
+}
+
+// Leader returns the candidate id of the current leader of the election.
+// Returns os.ErrNotExist if the election has no leader. Leader may report the
+// previous leader for a short duration while a new leader is taking over.
+func (d *Database) Leader(ctx context.Context, election string) (_ string, status error) {
+	ctx, span := d.startSpan(ctx, "kvpostgres.Leader", Attribute{Key: AttrKey, Value: election})
+	defer func() { endSpan(span, status) }()
+
+	if len(election) == 0 {
+		return "", os.ErrInvalid
+	}
+
+	// Advisory locks are not visible on the replicas, so the snapshot is always
+	// taken on the primary.
+	snap, err := d.begin(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable, ReadOnly: true})
+	if err != nil {
+		return "", err
+	}
+	defer snap.Discard(ctx)
+
+	// Leader key is left behind when the leader's connection is lost, so it is
+	// valid only while the election lock is held.
+	key1, key2 := lockKeys(electionLockName(election))
+	q := "SELECT EXISTS (SELECT 1 FROM pg_locks WHERE locktype = 'advisory' AND classid = $1::bigint::oid AND objid = $2::bigint::oid AND objsubid = 2 AND granted)"
+	var held bool
+	if err := snap.tx.QueryRowContext(ctx, q, int64(uint32(key1)), int64(uint32(key2))).Scan(&held); err != nil {
+		return "", err
+	}
+	if !held {
+		return "", os.ErrNotExist
+	}
+
+	r, err := snap.Get(ctx, ElectionKeyPrefix+election)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	if _, err := io.Copy(&sb, r); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// Election returns the election name.
+func (le *Leadership) Election() string {
+	return le.election
+}
+
+// Candidate returns the candidate id of the leader.
+func (le *Leadership) Candidate() string {
+	return le.candidate
+}
+
+// Lost returns a channel that is closed when the leadership is lost or
+// resigned.
+func (le *Leadership) Lost() <-chan struct{} {
+	return le.lock.Lost()
+}
+
+// Err returns nil while the leadership is held, os.ErrClosed after the
+// leadership is resigned and ErrLockLost after the leadership is lost.
+func (le *Leadership) Err() error {
+	return le.lock.Err()
+}
+
+// Resign removes the leader's candidate id from the key-value store and
+// gives up the leadership, so that another candidate can become the leader.
+func (le *Leadership) Resign(ctx context.Context) error {
+	if err := le.lock.Err(); err != nil {
+		return err
+	}
+	if err := le.lock.db.clearLeader(ctx, le.election, le.candidate); err != nil {
+		return errors.Join(err, le.lock.Unlock(ctx))
+	}
+	return le.lock.Unlock(ctx)
+}
+
+func (d *Database) setLeader(ctx context.Context, election, candidate string) error {
+	tx, err := d.NewTransaction(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := tx.Set(ctx, ElectionKeyPrefix+election, strings.NewReader(candidate)); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// clearLeader removes the leader key if it holds the given candidate id.
+func (d *Database) clearLeader(ctx context.Context, election, candidate string) error {
+	tx, err := d.NewTransaction(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	r, err := tx.Get(ctx, ElectionKeyPrefix+election)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	var sb strings.Builder
+	if _, err := io.Copy(&sb, r); err != nil {
+		return err
+	}
+	if sb.String() != candidate {
+		return nil
+	}
+	if err := tx.Delete(ctx, ElectionKeyPrefix+election); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// electionLockName returns the lock name of an election.
+func electionLockName(election string) string {
+	return "kvpostgres/election/" + election
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCampaign(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	db, err := New(ctx, dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Leader(ctx, "scheduler"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("wanted os.ErrNotExist without a leader, got %v", err)
+	}
+
+	le1, err := db.Campaign(ctx, "scheduler", "node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := db.Leader(ctx, "scheduler"); err != nil || id != "node-1" {
+		t.Fatalf("got leader %q, %v, want node-1", id, err)
+	}
+
+	// Second candidate waits till the first one resigns.
+	elected := make(chan *Leadership)
+	go func() {
+		le2, err := db.Campaign(ctx, "scheduler", "node-2")
+		if err != nil {
+			t.Error(err)
+		}
+		elected <- le2
+	}()
+	select {
+	case <-elected:
+		t.Fatalf("second candidate is elected while the first is the leader")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if err := le1.Resign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-le1.Lost():
+	default:
+		t.Errorf("wanted the lost channel to be closed after resign")
+	}
+
+	le2 := <-elected
+	if le2 == nil {
+		t.FailNow()
+	}
+	if id, err := db.Leader(ctx, "scheduler"); err != nil || id != "node-2" {
+		t.Fatalf("got leader %q, %v, want node-2", id, err)
+	}
+
+	// Leadership is lost when the session connection drops.
+	var pid int
+	if err := le2.lock.conn.QueryRowContext(ctx, "SELECT pg_backend_pid()").Scan(&pid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.db.ExecContext(ctx, "SELECT pg_terminate_backend($1)", pid); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-le2.Lost():
+	case <-time.After(10 * lockRenewInterval):
+		t.Fatalf("leadership is not reported as lost after the connection is terminated")
+	}
+	if _, err := db.Leader(ctx, "scheduler"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("wanted os.ErrNotExist after the leader is lost, got %v", err)
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"os"
+)
+
+// dataKeySize is the size of the per-value AES-256 data keys.
+const dataKeySize = 32
+
+// KeyProvider provides the master keys for envelope encryption of the values.
+//
+// Every value is encrypted with AES-GCM using a random data key, which is
+// encrypted (wrapped) with the current master key and stored with the value
+// along with the master key identifier. Values are authenticated with their
+// keys, so they cannot be swapped between keys.
+//
+// Keys are not encrypted. JSON documents cannot be encrypted, so encryption
+// cannot be used with JSON keyspaces. Older versions in the history table
+// keep their master key identifiers, so providers must keep the older master
+// keys available as long as they are needed.
+type KeyProvider interface {
+	// CurrentKey returns the identifier and the master key that is used to
+	// encrypt the new values. Master keys must be 16, 24 or 32 bytes long to
+	// select AES-128, AES-192 or AES-256. Identifiers must not be empty and must
+	// not be longer than 255 bytes.
+	CurrentKey(ctx context.Context) (id string, key []byte, err error)
+
+	// Key returns the master key for an identifier returned earlier by the
+	// CurrentKey method.
+	Key(ctx context.Context, id string) ([]byte, error)
+}
+
+var errNoKeyProvider = fmt.Errorf("value is encrypted, but key provider is not configured: %w", os.ErrInvalid)
+
+// RotateKeys re-encrypts all values that are not encrypted with the current
+// master key, including the values stored without encryption, and returns
+// the number of values re-encrypted. Values are processed in batches of the
+// given size, each in a separate transaction, so it can run concurrently
+// with other transactions. RotateKeys can be retried after failures because
+// it only processes the values that need re-encryption.
+//
+// Older versions in the history table are not re-encrypted, because history
+// is immutable. They remain readable only with their original master keys
+// till they are pruned by Options.HistoryRetention or PruneHistory, so a
+// retired master key must stay available till then.
+func (d *Database) RotateKeys(ctx context.Context, batchSize int) (_ int64, status error) {
+	ctx, span := d.startSpan(ctx, "kvpostgres.RotateKeys")
+	defer func() { endSpan(span, status) }()
+
+	if d.keys == nil {
+		return 0, fmt.Errorf("key provider is not configured: %w", os.ErrInvalid)
+	}
+	if batchSize <= 0 {
+		return 0, os.ErrInvalid
+	}
+
+	current, _, err := d.keys.CurrentKey(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var nrotated int64
+	for after := ""; ; {
+		n, last, err := d.rotateBatch(ctx, current, after, batchSize)
+		if err != nil {
+			return nrotated, err
+		}
+		nrotated += n
+		if len(last) == 0 {
+			return nrotated, nil
+		}
+		after = last
+	}
+}
+
+// rotateBatch re-encrypts the values in a batch of keys after the given key
+// and returns the number of values re-encrypted and the last key in the
+// batch, which is empty when there are no more keys.
+func (d *Database) rotateBatch(ctx context.Context, current, after string, batchSize int) (int64, string, error) {
+	tx, err := d.NewTransaction(ctx)
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback(ctx)
+
+	q := "SELECT key, value FROM kv WHERE key > $1 AND " + keyPrefix + " >= " + paramPrefix(1) + " ORDER BY " + keyOrder("ASC") + " LIMIT $2"
+	rows, err := tx.tx.QueryContext(ctx, q, []byte(after), batchSize)
+	if err != nil {
+		return 0, "", err
+	}
+	var keys []string
+	var values [][]byte
+	for rows.Next() {
+		var key string
+		var value []byte
+		if err := rows.Scan(&key, &value); err != nil {
+			rows.Close()
+			return 0, "", err
+		}
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, "", err
+	}
+	rows.Close()
+
+	var n int64
+	for i, key := range keys {
+		if id, ok := envelopeKeyID(values[i]); ok && id == current {
+			continue
+		}
+		value, err := d.decodeValue(ctx, key, values[i])
+		if err != nil {
+			return 0, "", err
+		}
+		data, err := d.encodeValue(ctx, key, value)
+		if err != nil {
+			return 0, "", err
+		}
+		// Values are updated in place because the contents are unchanged.
+		if _, err := tx.tx.ExecContext(ctx, "UPDATE kv SET value = $2 WHERE key = $1", []byte(key), data); err != nil {
+			return 0, "", err
+		}
+		n++
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, "", err
+	}
+
+	if len(keys) < batchSize {
+		return n, "", nil
+	}
+	return n, keys[len(keys)-1], nil
+}
+
+// encrypt encrypts the value of a key with a new data key and returns the
+// encrypted value with the header.
+//
+// Encrypted values are stored as the master key identifier size (one byte),
+// the master key identifier, the wrapped data key and the encrypted value.
+func (d *Database) encrypt(ctx context.Context, k string, value []byte) ([]byte, error) {
+	id, master, err := d.keys.CurrentKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(id) == 0 || len(id) > 255 {
+		return nil, fmt.Errorf("invalid master key identifier size %d: %w", len(id), os.ErrInvalid)
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	wrapped, err := seal(master, dataKey, []byte(id))
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := seal(dataKey, value, []byte(k))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(byte(len(id)))
+	buf.WriteString(id)
+	buf.Write(wrapped)
+	buf.Write(sealed)
+	return appendHeader(encryptedCodec, buf.Bytes()), nil
+}
+
+// decrypt decrypts an encrypted value of a key without the header.
+func (d *Database) decrypt(ctx context.Context, k string, data []byte) ([]byte, error) {
+	if d.keys == nil {
+		return nil, errNoKeyProvider
+	}
+	id, wrapped, sealed, err := parseEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	master, err := d.keys.Key(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := unseal(master, wrapped, []byte(id))
+	if err != nil {
+		return nil, err
+	}
+	return unseal(dataKey, sealed, []byte(k))
+}
+
+// envelopeKeyID returns the master key identifier of an encrypted value
+// with the header.
+func envelopeKeyID(data []byte) (string, bool) {
+	if len(data) < len(valueMagic)+1 || string(data[:len(valueMagic)]) != valueMagic || data[len(valueMagic)] != encryptedCodec {
+		return "", false
+	}
+	id, _, _, err := parseEnvelope(data[len(valueMagic)+1:])
+	if err != nil {
+		return "", false
+	}
+	return id, true
+}
+
+func parseEnvelope(data []byte) (id string, wrapped, sealed []byte, err error) {
+	// Wrapped data key has a nonce, the encrypted key and an auth tag.
+	wrappedSize := 12 + dataKeySize + 16
+	if len(data) < 1 || len(data) < 1+int(data[0])+wrappedSize {
+		return "", nil, nil, fmt.Errorf("truncated encrypted value: %w", os.ErrInvalid)
+	}
+	n := int(data[0])
+	id = string(data[1 : 1+n])
+	wrapped = data[1+n : 1+n+wrappedSize]
+	sealed = data[1+n+wrappedSize:]
+	return id, wrapped, sealed, nil
+}
+
+// seal encrypts the plaintext with AES-GCM and returns the random nonce
+// followed by the ciphertext.
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// unseal decrypts the output of seal.
+func unseal(key, data, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("truncated ciphertext: %w", os.ErrInvalid)
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testKeyProvider struct {
+	mu      sync.Mutex
+	current string
+	keys    map[string][]byte
+}
+
+func newTestKeyProvider(ids ...string) *testKeyProvider {
+	p := &testKeyProvider{keys: make(map[string][]byte)}
+	for _, id := range ids {
+		p.keys[id] = bytes.Repeat([]byte(id[:1]), 32)
+		p.current = id
+	}
+	return p
+}
+
+func (p *testKeyProvider) setCurrent(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.current = id
+}
+
+func (p *testKeyProvider) CurrentKey(ctx context.Context) (string, []byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.current, p.keys[p.current], nil
+}
+
+func (p *testKeyProvider) Key(ctx context.Context, id string) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return key, nil
+}
+
+func TestEncryptValue(t *testing.T) {
+	ctx := context.Background()
+
+	d := &Database{keys: newTestKeyProvider("k1")}
+	d.setCodecs([]Compression{{Codec: GzipCodec{}}}, nil)
+
+	value := []byte(strings.Repeat("secret ", 100))
+	data, err := d.encodeValue(ctx, "/a", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret")) {
+		t.Fatalf("encoded value has plaintext")
+	}
+	if id, ok := envelopeKeyID(data); !ok || id != "k1" {
+		t.Fatalf("wanted master key id k1, got %q", id)
+	}
+
+	got, err := d.decodeValue(ctx, "/a", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, value) {
+		t.Fatalf("value did not round trip")
+	}
+
+	// Encrypted values are bound to their keys.
+	if _, err := d.decodeValue(ctx, "/b", data); err == nil {
+		t.Fatalf("wanted error when decrypting with a different key")
+	}
+}
+
+func TestRotateKeys(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	keys := newTestKeyProvider("k1", "k2")
+	keys.setCurrent("k1")
+
+	db, err := NewWithOptions(ctx, dbDir, &Options{KeyProvider: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"/a", "/b", "/c", "/d", "/e"} {
+		if err := tx.Set(ctx, k, strings.NewReader(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	keys.setCurrent("k2")
+	n, err := db.RotateKeys(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Fatalf("wanted 5 values to be rotated, got %d", n)
+	}
+	if n, err := db.RotateKeys(ctx, 2); err != nil || n != 0 {
+		t.Fatalf("wanted no values to be rotated again, got %d (%v)", n, err)
+	}
+
+	// Old master key is not necessary after the rotation.
+	delete(keys.keys, "k1")
+
+	snap, err := db.NewSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Discard(ctx)
+
+	for k, v := range snap.Ascend(ctx, "", "", &err) {
+		data, err := io.ReadAll(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != k {
+			t.Fatalf("value of %s did not round trip", k)
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncryptJSONKeyspace(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	db, err := NewWithOptions(ctx, dbDir, &Options{KeyProvider: newTestKeyProvider("k1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateJSONKeyspace(ctx, "/docs/", false); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("wanted os.ErrInvalid for a JSON keyspace with encryption, got %v", err)
+	}
+	db.Close()
+
+	db, err = New(ctx, dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateJSONKeyspace(ctx, "/docs/", false); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if db, err := NewWithOptions(ctx, dbDir, &Options{KeyProvider: newTestKeyProvider("k1")}); !errors.Is(err, os.ErrInvalid) {
+		if err == nil {
+			db.Close()
+		}
+		t.Errorf("wanted os.ErrInvalid for opening a database with JSON keyspaces with encryption, got %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Version is a committed version of a key in the versioned mode.
+type Version struct {
+	// Revision is the commit revision that created the version.
+	Revision int64
+
+	// Timestamp is the commit time of the version. It is zero for the value a
+	// key had before its first write in the versioned mode.
+	Timestamp time.Time
+
+	// Deleted is true if the key was deleted in this version.
+	Deleted bool
+
+	// Value is the value of the key in this version. It is nil for the
+	// deleted versions.
+	Value []byte
+}
+
+var errNotVersioned = fmt.Errorf("database is not in versioned mode: %w", os.ErrInvalid)
+
+// NewSnapshotAsOf creates a read-only snapshot of the key-value database as
+// it was at the given time. Database must be opened in the versioned mode.
+//
+// History begins when the versioned mode is enabled, so the keys that were
+// not modified since then are visible with their current values at all
+// times, and the values the keys had before their first write in the
+// versioned mode are visible at all times before the write. Also, the older
+// versions pruned per Options.HistoryRetention are not available.
+func (d *Database) NewSnapshotAsOf(ctx context.Context, at time.Time) (_ *Transaction, status error) {
+	ctx, span := d.startSpan(ctx, "kvpostgres.NewSnapshotAsOf", Attribute{Key: "kv.as_of", Value: at.Format(time.RFC3339Nano)})
+	defer func() { endSpan(span, status) }()
+
+	if !d.versioned {
+		return nil, errNotVersioned
+	}
+	if at.IsZero() {
+		return nil, os.ErrInvalid
+	}
+
+	t, err := d.begin(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	t.asOf = at
+	return t, nil
+}
+
+// History returns the committed versions of a key in the descending order of
+// their revisions. Database must be opened in the versioned mode.
+func (t *Transaction) History(ctx context.Context, k string) (_ []*Version, status error) {
+	ctx, span := t.db.startSpan(ctx, "kvpostgres.History", Attribute{Key: AttrKey, Value: k})
+	defer func() { endSpan(span, status) }()
+
+	if t.tx == nil {
+		return nil, os.ErrClosed
+	}
+	if len(k) == 0 {
+		return nil, os.ErrInvalid
+	}
+	if !t.db.versioned {
+		return nil, errNotVersioned
+	}
+
+	// Baseline versions have an unknown (negative infinite) commit time.
+	q := "SELECT revision, NULLIF(committed_at, '-infinity'), deleted, value FROM kv_history WHERE key = $1 ORDER BY revision DESC"
+	rows, err := t.tx.QueryContext(ctx, q, []byte(k))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []*Version
+	for rows.Next() {
+		v := new(Version)
+		var timestamp sql.NullTime
+		if err := rows.Scan(&v.Revision, &timestamp, &v.Deleted, &v.Value); err != nil {
+			return nil, err
+		}
+		v.Timestamp = timestamp.Time
+		if v.Value != nil {
+			value, err := t.db.decodeValue(ctx, k, v.Value)
+			if err != nil {
+				return nil, err
+			}
+			v.Value = value
+		}
+		versions = append(versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// PruneHistory removes the versions that were superseded by newer versions
+// before the given time and returns the number of versions removed. Versions
+// that are required for the snapshots at or after the given time are kept.
+func (d *Database) PruneHistory(ctx context.Context, before time.Time) (_ int64, status error) {
+	ctx, span := d.startSpan(ctx, "kvpostgres.PruneHistory")
+	defer func() { endSpan(span, status) }()
+
+	if !d.versioned {
+		return 0, errNotVersioned
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var nrows int64
+	for _, q := range []string{
+		// Remove versions superseded before the cutoff time.
+		`DELETE FROM kv_history h WHERE committed_at < $1 AND EXISTS (SELECT 1 FROM kv_history n WHERE n.key = h.key AND n.revision > h.revision AND n.committed_at <= $1)`,
+		// Remove deleted markers that are the only versions left before the
+		// cutoff time.
+		`DELETE FROM kv_history h WHERE deleted AND committed_at < $1 AND NOT EXISTS (SELECT 1 FROM kv_history o WHERE o.key = h.key AND o.revision < h.revision)`,
+	} {
+		result, err := tx.ExecContext(ctx, q, before)
+		if err != nil {
+			return 0, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		nrows += n
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return nrows, nil
+}
+
+func (d *Database) pruneHistoryLoop() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(max(d.retention/10, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := d.PruneHistory(d.ctx, time.Now().Add(-d.retention))
+		if err != nil {
+			if d.ctx.Err() == nil {
+				slog.Warn("could not prune key history", "err", err)
+			}
+			continue
+		}
+		if n > 0 {
+			slog.Info("pruned key history", "versions", n)
+		}
+	}
+}
+
+// recordBaseline records the committed values of the keys before their first
+// write in the versioned mode, so that the snapshots before the write observe
+// them. Baseline versions have revision zero and are visible at all earlier
+// times, because the time of their commit is unknown. Keys that already have
+// a history or were written earlier by the transaction are skipped.
+func (t *Transaction) recordBaseline(ctx context.Context, keys ...string) error {
+	var bkeys [][]byte
+	for _, k := range keys {
+		if _, ok := t.writes[k]; !ok {
+			bkeys = append(bkeys, []byte(k))
+		}
+	}
+	if len(bkeys) == 0 {
+		return nil
+	}
+	q := `INSERT INTO kv_history (key, revision, committed_at, value, deleted) SELECT key, 0, '-infinity', value, FALSE FROM kv WHERE key = ANY($1) AND NOT EXISTS (SELECT 1 FROM kv_history h WHERE h.key = kv.key)`
+	if _, err := t.tx.ExecContext(ctx, q, pq.ByteaArray(bkeys)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// recordHistory adds new versions for the keys modified by the transaction.
+func (t *Transaction) recordHistory(ctx context.Context, revision int64, timestamp time.Time, keys, deletes [][]byte) error {
+	if len(keys) > 0 {
+		q := `INSERT INTO kv_history (key, revision, committed_at, value, deleted) SELECT key, $1, $2, value, FALSE FROM kv WHERE key = ANY($3)`
+		if _, err := t.tx.ExecContext(ctx, q, revision, timestamp, pq.ByteaArray(keys)); err != nil {
+			return err
+		}
+	}
+	if len(deletes) > 0 {
+		q := `INSERT INTO kv_history (key, revision, committed_at, value, deleted) SELECT key, $1, $2, NULL, TRUE FROM unnest($3::bytea[]) AS key`
+		if _, err := t.tx.ExecContext(ctx, q, revision, timestamp, pq.ByteaArray(deletes)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// source returns the table expression with the key-value pairs visible to the
+// transaction.
+func (t *Transaction) source() string {
+	if t.asOf.IsZero() {
+		return "kv"
+	}
+	// Latest version of every key at the snapshot time, plus the keys that
+	// were never modified in the versioned mode.
+	ts := pq.QuoteLiteral(t.asOf.UTC().Format(time.RFC3339Nano)) + "::timestamptz"
+	return "(SELECT key, value FROM (" +
+		"SELECT DISTINCT ON (key) key, value, deleted FROM kv_history WHERE committed_at <= " + ts + " ORDER BY key, revision DESC" +
+		") AS h WHERE NOT deleted" +
+		" UNION ALL " +
+		"SELECT key, value FROM kv WHERE NOT EXISTS (SELECT 1 FROM kv_history WHERE kv_history.key = kv.key)" +
+		") AS kv"
+}
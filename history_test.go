@@ -0,0 +1,263 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	db, err := NewWithOptions(ctx, dbDir, &Options{Versioned: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	update := func(value string) time.Time {
+		tx, err := db.NewTransaction(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value == "" {
+			err = tx.Delete(ctx, "/a")
+		} else {
+			err = tx.Set(ctx, "/a", strings.NewReader(value))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+		return time.Now()
+	}
+
+	before := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	t1 := update("one")
+	t2 := update("two")
+	t3 := update("")
+
+	tests := []struct {
+		at   time.Time
+		want string
+	}{
+		{before, ""},
+		{t1, "one"},
+		{t2, "two"},
+		{t3, ""},
+	}
+	for i, test := range tests {
+		snap, err := db.NewSnapshotAsOf(ctx, test.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := snap.Get(ctx, "/a")
+		if test.want == "" {
+			if !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("%d: wanted os.ErrNotExist, got %v", i, err)
+			}
+		} else {
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := io.ReadAll(r)
+			if string(data) != test.want {
+				t.Errorf("%d: wanted %q, got %q", i, test.want, data)
+			}
+		}
+
+		var keys []string
+		for k := range snap.Ascend(ctx, "", "", &err) {
+			keys = append(keys, k)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := len(keys); (test.want == "" && n != 0) || (test.want != "" && n != 1) {
+			t.Errorf("%d: unexpected keys %v in the snapshot", i, keys)
+		}
+		snap.Discard(ctx)
+	}
+
+	tx, err := db.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	versions, err := tx.History(ctx, "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || !versions[0].Deleted || string(versions[1].Value) != "two" || string(versions[2].Value) != "one" {
+		t.Fatalf("unexpected versions %+v", versions)
+	}
+
+	if _, err := db.PruneHistory(ctx, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if versions, err := tx.History(ctx, "/a"); err != nil || len(versions) != 3 {
+		t.Fatalf("wanted versions to be visible in the older transaction, got %d (%v)", len(versions), err)
+	}
+}
+
+func TestHistoryParallelScan(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	db, err := NewWithOptions(ctx, dbDir, &Options{Versioned: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	update := func(value string) {
+		tx, err := db.NewTransaction(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i++ {
+			if err := tx.Set(ctx, fmt.Sprintf("/%03d", i), strings.NewReader(value)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tx.Commit(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	update("old")
+	time.Sleep(10 * time.Millisecond)
+	at := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	update("new")
+
+	snap, err := db.NewSnapshotAsOf(ctx, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Discard(ctx)
+
+	var mu sync.Mutex
+	seen := make(map[string]string)
+	err = snap.ParallelScan(ctx, "", "", 4, func(k string, v io.Reader) error {
+		data, err := io.ReadAll(v)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		seen[k] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 100 {
+		t.Errorf("got %d keys, want 100", len(seen))
+	}
+	for k, v := range seen {
+		if v != "old" {
+			t.Errorf("key %q: got value %q, want the value at the snapshot time", k, v)
+		}
+	}
+}
+
+func TestHistoryBaseline(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	// Keys are written before the versioned mode is enabled.
+	db, err := New(ctx, dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"/a", "/b", "/c/1", "/c/2"} {
+		if err := tx.Set(ctx, k, strings.NewReader("old")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = NewWithOptions(ctx, dbDir, &Options{Versioned: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	time.Sleep(10 * time.Millisecond)
+	before := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	tx, err = db.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Set(ctx, "/a", strings.NewReader("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Delete(ctx, "/b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.DeletePrefix(ctx, "/c/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := db.NewSnapshotAsOf(ctx, before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Discard(ctx)
+
+	got := make(map[string]string)
+	for k, v := range snap.Ascend(ctx, "", "", &err) {
+		data, _ := io.ReadAll(v)
+		got[k] = string(data)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"/a", "/b", "/c/1", "/c/2"} {
+		if got[k] != "old" {
+			t.Errorf("key %q: got %q, want the value before the versioned write", k, got[k])
+		}
+	}
+
+	versions, err := snap.History(ctx, "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || string(versions[0].Value) != "new" || string(versions[1].Value) != "old" || !versions[1].Timestamp.IsZero() {
+		t.Fatalf("unexpected versions %+v", versions)
+	}
+}
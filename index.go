@@ -0,0 +1,208 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"io"
+	"iter"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/lib/pq"
+)
+
+// Extractor returns the index keys for a key-value pair. Empty index keys are
+// ignored.
+type Extractor func(key string, value []byte) []string
+
+// CreateIndex registers a secondary index with the given name and builds the
+// index entries for all existing key-value pairs. Index entries are
+// maintained automatically by all later Set and Delete operations.
+//
+// Indexes are registered only with this Database instance, so all users that
+// modify the database must register the same indexes (typically at startup)
+// to keep the index entries consistent. Registering an index again rebuilds
+// its entries.
+func (d *Database) CreateIndex(ctx context.Context, name string, extractor Extractor) (status error) {
+	ctx, span := d.startSpan(ctx, "kvpostgres.CreateIndex", Attribute{Key: "kv.index", Value: name})
+	defer func() { endSpan(span, status) }()
+
+	if len(name) == 0 || extractor == nil {
+		return os.ErrInvalid
+	}
+
+	// Extractor is registered before the backfill, so that the concurrent
+	// transactions maintain the index as well, and the registration is undone
+	// if the backfill fails.
+	d.mu.Lock()
+	prev, replaced := d.indexes[name]
+	indexes := maps.Clone(d.indexes)
+	if indexes == nil {
+		indexes = make(map[string]Extractor)
+	}
+	indexes[name] = extractor
+	d.indexes = indexes
+	d.mu.Unlock()
+
+	defer func() {
+		if status == nil {
+			return
+		}
+		d.mu.Lock()
+		indexes := maps.Clone(d.indexes)
+		if replaced {
+			indexes[name] = prev
+		} else {
+			delete(indexes, name)
+		}
+		d.indexes = indexes
+		d.mu.Unlock()
+	}()
+
+	tx, err := d.NewTransaction(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.tx.ExecContext(ctx, "DELETE FROM kv_index WHERE name = $1", name); err != nil {
+		return err
+	}
+
+	var iterErr error
+	for k, v := range tx.Ascend(ctx, "", "", &iterErr) {
+		value, err := io.ReadAll(v)
+		if err != nil {
+			return err
+		}
+		if err := tx.insertIndexEntries(ctx, k, []string{name}, [][]string{extractor(k, value)}); err != nil {
+			return err
+		}
+	}
+	if iterErr != nil {
+		return iterErr
+	}
+	return tx.Commit(ctx)
+}
+
+// DropIndex unregisters a secondary index and removes all its entries.
+func (d *Database) DropIndex(ctx context.Context, name string) (status error) {
+	ctx, span := d.startSpan(ctx, "kvpostgres.DropIndex", Attribute{Key: "kv.index", Value: name})
+	defer func() { endSpan(span, status) }()
+
+	if len(name) == 0 {
+		return os.ErrInvalid
+	}
+
+	d.mu.Lock()
+	indexes := maps.Clone(d.indexes)
+	delete(indexes, name)
+	d.indexes = indexes
+	d.mu.Unlock()
+
+	if _, err := d.db.ExecContext(ctx, "DELETE FROM kv_index WHERE name = $1", name); err != nil {
+		return err
+	}
+	return nil
+}
+
+// LookupIndex returns the key-value pairs with the given index key in the
+// named index, in ascending order of the keys.
+func (t *Transaction) LookupIndex(ctx context.Context, name, indexKey string, errp *error) iter.Seq2[string, io.Reader] {
+	return func(yield func(string, io.Reader) bool) {
+		if len(indexKey) == 0 {
+			*errp = os.ErrInvalid
+			return
+		}
+		t.AscendIndex(ctx, name, indexKey, indexKey+"\x00", errp)(yield)
+	}
+}
+
+// AscendIndex returns the key-value pairs with index keys in the given range
+// of the named index, in ascending order of the index keys and the keys.
+// Range semantics for the index keys are same as Ascend.
+func (t *Transaction) AscendIndex(ctx context.Context, name, beg, end string, errp *error) iter.Seq2[string, io.Reader] {
+	return func(yield func(string, io.Reader) bool) {
+		ctx, span := t.db.startSpan(ctx, "kvpostgres.AscendIndex", Attribute{Key: "kv.index", Value: name}, Attribute{Key: AttrBegin, Value: beg}, Attribute{Key: AttrEnd, Value: end})
+		defer func() { endSpan(span, *errp) }()
+
+		if t.tx == nil {
+			*errp = os.ErrClosed
+			return
+		}
+		if beg > end && end != "" {
+			*errp = os.ErrInvalid
+			return
+		}
+		// Index entries are not versioned.
+		if !t.asOf.IsZero() {
+			*errp = os.ErrInvalid
+			return
+		}
+
+		t.db.mu.Lock()
+		_, ok := t.db.indexes[name]
+		t.db.mu.Unlock()
+		if !ok {
+			*errp = os.ErrNotExist
+			return
+		}
+
+		cond, args := columnRange("i.ikey", beg, end, 2)
+		q := "SELECT kv.key, kv.value FROM kv_index i JOIN kv ON kv.key = i.key WHERE i.name = $1 AND " + cond + " ORDER BY i.ikey, i.key"
+		t.cursor(ctx, q, append([]any{name}, args...), errp)(yield)
+	}
+}
+
+// updateIndexes replaces the index entries of a key in all registered
+// indexes. Value is nil when the key is deleted.
+func (t *Transaction) updateIndexes(ctx context.Context, k string, value []byte) error {
+	t.db.mu.Lock()
+	indexes := t.db.indexes
+	t.db.mu.Unlock()
+
+	if len(indexes) == 0 {
+		return nil
+	}
+
+	names := slices.Sorted(maps.Keys(indexes))
+	if _, err := t.tx.ExecContext(ctx, "DELETE FROM kv_index WHERE key = $1 AND name = ANY($2)", []byte(k), pq.Array(names)); err != nil {
+		return err
+	}
+	if value == nil {
+		return nil
+	}
+
+	ikeys := make([][]string, len(names))
+	for i, name := range names {
+		ikeys[i] = indexes[name](k, value)
+	}
+	return t.insertIndexEntries(ctx, k, names, ikeys)
+}
+
+// insertIndexEntries adds the index entries for a key. Index keys for the
+// i-th index name are in ikeys[i].
+func (t *Transaction) insertIndexEntries(ctx context.Context, k string, names []string, ikeys [][]string) error {
+	var entryNames []string
+	var entryKeys [][]byte
+	for i, name := range names {
+		for _, ikey := range ikeys[i] {
+			if len(ikey) == 0 {
+				continue
+			}
+			entryNames = append(entryNames, name)
+			entryKeys = append(entryKeys, []byte(ikey))
+		}
+	}
+	if len(entryNames) == 0 {
+		return nil
+	}
+
+	q := "INSERT INTO kv_index (name, ikey, key) SELECT unnest($1::text[]), unnest($2::bytea[]), $3::bytea ON CONFLICT DO NOTHING"
+	if _, err := t.tx.ExecContext(ctx, q, pq.Array(entryNames), pq.ByteaArray(entryKeys), []byte(k)); err != nil {
+		return err
+	}
+	return nil
+}
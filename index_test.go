@@ -0,0 +1,127 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	db, err := New(ctx, dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Values are "<city>,<name>" and the index is on the city.
+	set := func(kvs ...string) {
+		tx, err := db.NewTransaction(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i+1 < len(kvs); i += 2 {
+			if kvs[i+1] == "" {
+				err = tx.Delete(ctx, kvs[i])
+			} else {
+				err = tx.Set(ctx, kvs[i], strings.NewReader(kvs[i+1]))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tx.Commit(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lookup := func(city string) []string {
+		snap, err := db.NewSnapshot(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer snap.Discard(ctx)
+
+		var keys []string
+		for k := range snap.LookupIndex(ctx, "city", city, &err) {
+			keys = append(keys, k)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return keys
+	}
+
+	set("/u1", "paris,alice", "/u2", "tokyo,bob")
+
+	err = db.CreateIndex(ctx, "city", func(key string, value []byte) []string {
+		city, _, _ := strings.Cut(string(value), ",")
+		return []string{city}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Backfilled entries.
+	if keys := lookup("paris"); !reflect.DeepEqual(keys, []string{"/u1"}) {
+		t.Fatalf("wanted [/u1], got %v", keys)
+	}
+
+	// Maintained entries.
+	set("/u3", "paris,carol", "/u1", "tokyo,alice", "/u2", "")
+	if keys := lookup("paris"); !reflect.DeepEqual(keys, []string{"/u3"}) {
+		t.Fatalf("wanted [/u3], got %v", keys)
+	}
+	if keys := lookup("tokyo"); !reflect.DeepEqual(keys, []string{"/u1"}) {
+		t.Fatalf("wanted [/u1], got %v", keys)
+	}
+}
+
+func TestCreateIndexFailure(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	db, err := New(ctx, dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Set(ctx, "/a", strings.NewReader("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Backfill fails because the context is canceled by the extractor.
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	extractor := func(k string, v []byte) []string {
+		cancel()
+		return []string{string(v)}
+	}
+	if err := db.CreateIndex(cctx, "value", extractor); err == nil {
+		t.Fatalf("wanted non-nil error for a failed backfill")
+	}
+
+	db.mu.Lock()
+	_, ok := db.indexes["value"]
+	db.mu.Unlock()
+	if ok {
+		t.Errorf("wanted the index to be unregistered after a failed backfill")
+	}
+}
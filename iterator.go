@@ -0,0 +1,232 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// seekBatchSize is the number of rows fetched at a time when seeking.
+const seekBatchSize = 64
+
+// Iterator is a bidirectional iterator over the key-value pairs in a range,
+// which is backed by a scrollable server-side cursor. Iterator is positioned
+// before the first key when it is created. Iterator is valid only till the
+// transaction is committed or rolled back and must be closed after use.
+type Iterator struct {
+	t    *Transaction
+	ctx  context.Context
+	name string
+
+	beg, end string
+
+	valid bool
+	key   string
+	value []byte
+	err   error
+}
+
+// NewIterator returns a new iterator over the key-value pairs in the given
+// range in ascending order. Range semantics and validation are same as
+// Ascend.
+func (t *Transaction) NewIterator(ctx context.Context, beg, end string) (_ *Iterator, status error) {
+	ctx, span := t.db.startSpan(ctx, "kvpostgres.NewIterator", Attribute{Key: AttrBegin, Value: beg}, Attribute{Key: AttrEnd, Value: end})
+	defer func() { endSpan(span, status) }()
+
+	if t.tx == nil {
+		return nil, os.ErrClosed
+	}
+	if beg > end && end != "" {
+		return nil, os.ErrInvalid
+	}
+
+	cond, args := keyRange(beg, end, 1)
+	q := "SELECT key, value FROM " + t.source() + " WHERE " + cond + " ORDER BY " + keyOrder("ASC")
+	// NOTE: Cursor name cannot be passed as a value parameter using $1 syntax.
+	name := fmt.Sprintf("it%d", time.Now().UnixNano())
+	if _, err := t.tx.ExecContext(ctx, fmt.Sprintf("DECLARE %s SCROLL CURSOR FOR %s", name, q), args...); err != nil {
+		return nil, err
+	}
+
+	it := &Iterator{
+		t:    t,
+		ctx:  ctx,
+		name: name,
+		beg:  beg,
+		end:  end,
+	}
+	return it, nil
+}
+
+// Next moves the iterator to the next key and returns true if the iterator
+// is positioned at a key. Next moves to the first key when the iterator is
+// before the first key.
+func (it *Iterator) Next() bool {
+	return it.fetch("FETCH NEXT FROM " + it.name)
+}
+
+// Prev moves the iterator to the previous key and returns true if the
+// iterator is positioned at a key. Prev moves to the last key when the
+// iterator is after the last key.
+func (it *Iterator) Prev() bool {
+	return it.fetch("FETCH PRIOR FROM " + it.name)
+}
+
+// Seek moves the iterator to the first key that is greater than or equal to
+// the given key and returns true if such key exists in the range. Iterator
+// is positioned after the last key otherwise.
+//
+// Seek scans the cursor forward from the current key when the given key is
+// after it, and from the start of the range otherwise, so its cost is
+// proportional to the number of keys skipped.
+func (it *Iterator) Seek(key string) bool {
+	valid, cur := it.valid, it.key
+	it.valid, it.key, it.value = false, "", nil
+	if it.err != nil {
+		return false
+	}
+	if it.name == "" || it.t.tx == nil {
+		it.setErr(os.ErrClosed)
+		return false
+	}
+
+	if !valid || key <= cur {
+		if _, err := it.t.tx.ExecContext(it.ctx, "MOVE ABSOLUTE 0 IN "+it.name); err != nil {
+			it.setErr(err)
+			return false
+		}
+	}
+	q := fmt.Sprintf("FETCH FORWARD %d FROM %s", seekBatchSize, it.name)
+	for {
+		found, n, k, data, err := it.seekBatch(q, key)
+		if err != nil {
+			it.setErr(err)
+			return false
+		}
+		if found < 0 {
+			if n < seekBatchSize {
+				return false
+			}
+			continue
+		}
+		// Cursor is moved back from the last fetched row to the found row.
+		if back := n - 1 - found; back > 0 {
+			if _, err := it.t.tx.ExecContext(it.ctx, fmt.Sprintf("MOVE RELATIVE -%d IN %s", back, it.name)); err != nil {
+				it.setErr(err)
+				return false
+			}
+		}
+		value, err := it.t.db.decodeValue(it.ctx, string(k), data)
+		if err != nil {
+			it.setErr(err)
+			return false
+		}
+		it.valid, it.key, it.value = true, string(k), value
+		return true
+	}
+}
+
+// seekBatch runs a FETCH FORWARD statement and returns the index of the first
+// fetched row with a key greater than or equal to the given key, or -1 if
+// there is none, along with the number of fetched rows and the found row.
+func (it *Iterator) seekBatch(q, key string) (found, n int, k, data []byte, status error) {
+	rows, err := it.t.tx.QueryContext(it.ctx, q)
+	if err != nil {
+		return -1, 0, nil, nil, err
+	}
+	defer rows.Close()
+
+	found = -1
+	for ; rows.Next(); n++ {
+		var rk, rdata []byte
+		if err := rows.Scan(&rk, &rdata); err != nil {
+			return -1, 0, nil, nil, err
+		}
+		if found < 0 && string(rk) >= key {
+			found, k, data = n, rk, rdata
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return -1, 0, nil, nil, err
+	}
+	return found, n, k, data, nil
+}
+
+// Key returns the current key. It is empty when the iterator is not
+// positioned at a key.
+func (it *Iterator) Key() string {
+	return it.key
+}
+
+// Value returns the value of the current key. It is nil when the iterator is
+// not positioned at a key.
+func (it *Iterator) Value() io.Reader {
+	if !it.valid {
+		return nil
+	}
+	return bytes.NewReader(it.value)
+}
+
+// Err returns the first error encountered by the iterator.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close releases the server-side cursor of the iterator. Iterator cannot be
+// used after it is closed.
+func (it *Iterator) Close() error {
+	if it.name == "" {
+		return os.ErrClosed
+	}
+	name := it.name
+	it.name = ""
+	it.valid, it.key, it.value = false, "", nil
+	if it.t.tx == nil {
+		return nil
+	}
+	if _, err := it.t.tx.ExecContext(it.ctx, "CLOSE "+name); err != nil {
+		return err
+	}
+	return nil
+}
+
+// fetch runs a FETCH statement and updates the current key-value pair.
+func (it *Iterator) fetch(q string) bool {
+	it.valid, it.key, it.value = false, "", nil
+	if it.err != nil {
+		return false
+	}
+	if it.name == "" || it.t.tx == nil {
+		it.setErr(os.ErrClosed)
+		return false
+	}
+
+	var key, data []byte
+	if err := it.t.tx.QueryRowContext(it.ctx, q).Scan(&key, &data); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			it.setErr(err)
+		}
+		return false
+	}
+	value, err := it.t.db.decodeValue(it.ctx, string(key), data)
+	if err != nil {
+		it.setErr(err)
+		return false
+	}
+	it.valid, it.key, it.value = true, string(key), value
+	return true
+}
+
+func (it *Iterator) setErr(err error) {
+	it.valid, it.key, it.value = false, "", nil
+	if it.err == nil {
+		it.err = err
+	}
+}
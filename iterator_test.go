@@ -0,0 +1,148 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIterator(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	db, err := New(ctx, dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	for _, k := range []string{"/a", "/b", "/c", "/d", "/e"} {
+		if err := tx.Set(ctx, k, strings.NewReader(k[1:])); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	it, err := tx.NewIterator(ctx, "/b", "/e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	check := func(step string, ok bool, want string) {
+		t.Helper()
+		if ok != (want != "") || it.Key() != want {
+			t.Fatalf("%s: got %v %q, want %q", step, ok, it.Key(), want)
+		}
+		if want != "" {
+			if data, _ := io.ReadAll(it.Value()); string(data) != want[1:] {
+				t.Fatalf("%s: got value %q, want %q", step, data, want[1:])
+			}
+		}
+	}
+
+	check("prev before first", it.Prev(), "")
+	check("next", it.Next(), "/b")
+	check("next", it.Next(), "/c")
+	check("prev", it.Prev(), "/b")
+	check("seek existing", it.Seek("/d"), "/d")
+	check("next", it.Next(), "")
+	check("prev after last", it.Prev(), "/d")
+	check("seek between", it.Seek("/bb"), "/c")
+	check("seek before range", it.Seek("/a"), "/b")
+	check("seek after range", it.Seek("/z"), "")
+	check("prev after seek past end", it.Prev(), "/d")
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if it.Next() || !errors.Is(it.Err(), os.ErrClosed) {
+		t.Errorf("wanted os.ErrClosed after close, got %v", it.Err())
+	}
+	if _, err := tx.NewIterator(ctx, "/b", "/a"); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("wanted os.ErrInvalid for an invalid range, got %v", err)
+	}
+}
+
+func TestIteratorSeekAfterWrites(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	db, err := New(ctx, dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	var keys []string
+	for i := 0; i < 2*seekBatchSize; i++ {
+		keys = append(keys, fmt.Sprintf("/%03d", 2*i))
+	}
+	for _, k := range keys {
+		if err := tx.Set(ctx, k, strings.NewReader(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	it, err := tx.NewIterator(ctx, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	// Cursor does not observe the writes made after it is created.
+	if err := tx.Set(ctx, "/001", strings.NewReader("/001")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Delete(ctx, "/002"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		seek, want string
+	}{
+		{"/001", "/002"},
+		{"/003", "/004"},
+		{"/200", "/200"},
+		{"/201", "/202"},
+		{"/100", "/100"},
+		{"/255", ""},
+		{"/000", "/000"},
+	} {
+		ok := it.Seek(test.seek)
+		if ok != (test.want != "") || it.Key() != test.want {
+			t.Fatalf("seek %q: got %v %q, want %q", test.seek, ok, it.Key(), test.want)
+		}
+	}
+	if !it.Next() || it.Key() != "/002" {
+		t.Fatalf("next after seek: got %q, want /002", it.Key())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
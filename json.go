@@ -0,0 +1,200 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"slices"
+	"strings"
+)
+
+var errJSONEncrypted = fmt.Errorf("JSON keyspaces cannot be used with encryption: %w", os.ErrInvalid)
+
+// CreateJSONKeyspace marks all keys with the given prefix as a JSON keyspace.
+// Values in a JSON keyspace must be valid JSON documents, which are also
+// stored as JSONB in the database, so that they can be filtered on the
+// server with Transaction.Query. Existing values with the prefix are
+// validated and converted. When ginIndex is true, a GIN index is created on
+// the JSON documents to speed up the queries.
+//
+// Keyspaces are cached when the database is opened and the cache is verified
+// by every Set in its transaction, so other Database instances observe the
+// new keyspaces on their next write. JSON documents cannot be encrypted, so
+// JSON keyspaces cannot be created when the database is opened with a
+// KeyProvider.
+func (d *Database) CreateJSONKeyspace(ctx context.Context, prefix string, ginIndex bool) (status error) {
+	ctx, span := d.startSpan(ctx, "kvpostgres.CreateJSONKeyspace", Attribute{Key: AttrKey, Value: prefix})
+	defer func() { endSpan(span, status) }()
+
+	if len(prefix) == 0 {
+		return os.ErrInvalid
+	}
+	if d.keys != nil {
+		return errJSONEncrypted
+	}
+
+	tx, err := d.NewTransaction(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	q := "INSERT INTO kv_keyspaces (prefix, kind) VALUES ($1, 'json') ON CONFLICT (prefix) DO UPDATE SET kind = EXCLUDED.kind"
+	if _, err := tx.tx.ExecContext(ctx, q, []byte(prefix)); err != nil {
+		return err
+	}
+
+	var iterErr error
+	for k, v := range tx.Ascend(ctx, prefix, prefixEnd(prefix), &iterErr) {
+		value, err := io.ReadAll(v)
+		if err != nil {
+			return err
+		}
+		if !json.Valid(value) {
+			return fmt.Errorf("value of key %q is not valid JSON: %w", k, os.ErrInvalid)
+		}
+		if _, err := tx.tx.ExecContext(ctx, "UPDATE kv SET doc = $2::jsonb WHERE key = $1", []byte(k), string(value)); err != nil {
+			return err
+		}
+	}
+	if iterErr != nil {
+		return iterErr
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	if !slices.Contains(d.jsonPrefixes, prefix) {
+		d.jsonPrefixes = append(slices.Clip(d.jsonPrefixes), prefix)
+	}
+	d.mu.Unlock()
+
+	if ginIndex {
+		if _, err := d.db.ExecContext(ctx, "CREATE INDEX IF NOT EXISTS kv_doc ON kv USING GIN (doc jsonb_path_ops)"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Query returns the key-value pairs in the given range whose JSON documents
+// match the SQL/JSON path filter, in ascending order. Filtering is performed
+// on the server as per the postgres jsonb_path_exists function, so only the
+// keys in JSON keyspaces can match. Range semantics are same as Ascend.
+//
+// For example, the filter `$.tags[*] ? (@ == "urgent")` selects the documents
+// with an "urgent" tag.
+func (t *Transaction) Query(ctx context.Context, beg, end, filter string, errp *error) iter.Seq2[string, io.Reader] {
+	return func(yield func(string, io.Reader) bool) {
+		ctx, span := t.db.startSpan(ctx, "kvpostgres.Query", Attribute{Key: AttrBegin, Value: beg}, Attribute{Key: AttrEnd, Value: end}, Attribute{Key: "kv.filter", Value: filter})
+		defer func() { endSpan(span, *errp) }()
+
+		if t.tx == nil {
+			*errp = os.ErrClosed
+			return
+		}
+		if beg > end && end != "" {
+			*errp = os.ErrInvalid
+			return
+		}
+		// JSON documents are not versioned.
+		if len(filter) == 0 || !t.asOf.IsZero() {
+			*errp = os.ErrInvalid
+			return
+		}
+
+		// The @? operator is same as jsonb_path_exists, but can use the GIN index.
+		cond, args := keyRange(beg, end, 2)
+		q := "SELECT key, value FROM kv WHERE doc @? $1::jsonpath AND " + cond + " ORDER BY " + keyOrder("ASC")
+		t.cursor(ctx, q, append([]any{filter}, args...), errp)(yield)
+	}
+}
+
+// jsonDoc returns the JSON document to be stored for a value or nil if the
+// key is not in a JSON keyspace, along with the number of the JSON keyspaces
+// known to this instance that contain the key, which is used to detect the
+// new keyspaces. See Transaction.Set.
+func (d *Database) jsonDoc(k string, value []byte) (any, int, error) {
+	d.mu.Lock()
+	prefixes := d.jsonPrefixes
+	d.mu.Unlock()
+
+	n := 0
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(k, prefix) {
+			n++
+		}
+	}
+	if n == 0 {
+		return nil, 0, nil
+	}
+	// Documents are stored in plaintext.
+	if d.keys != nil {
+		return nil, 0, errJSONEncrypted
+	}
+	if !json.Valid(value) {
+		return nil, 0, fmt.Errorf("value is not valid JSON: %w", os.ErrInvalid)
+	}
+	return string(value), n, nil
+}
+
+// reloadJSONKeyspaces adds the JSON keyspaces visible to the transaction to
+// the keyspaces known to this instance.
+func (t *Transaction) reloadJSONKeyspaces(ctx context.Context) error {
+	prefixes, err := loadJSONKeyspaces(ctx, t.tx)
+	if err != nil {
+		return err
+	}
+
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+	for _, prefix := range prefixes {
+		if !slices.Contains(t.db.jsonPrefixes, prefix) {
+			t.db.jsonPrefixes = append(slices.Clip(t.db.jsonPrefixes), prefix)
+		}
+	}
+	return nil
+}
+
+func loadJSONKeyspaces(ctx context.Context, db interface {
+	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+}) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT prefix FROM kv_keyspaces WHERE kind = 'json'")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var prefixes []string
+	for rows.Next() {
+		var prefix string
+		if err := rows.Scan(&prefix); err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return prefixes, nil
+}
+
+// prefixEnd returns the smallest key that is greater than all keys with the
+// given prefix, or empty string if there is no such key.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPrefixEnd(t *testing.T) {
+	tests := map[string]string{
+		"a":        "b",
+		"a\xff":    "b",
+		"\xff\xff": "",
+		"/docs/":   "/docs0",
+	}
+	for prefix, want := range tests {
+		if got := prefixEnd(prefix); got != want {
+			t.Errorf("prefixEnd(%q): wanted %q, got %q", prefix, want, got)
+		}
+	}
+}
+
+func TestJSONKeyspace(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	db, err := New(ctx, dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.CreateJSONKeyspace(ctx, "/docs/", true); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := tx.Set(ctx, "/docs/bad", strings.NewReader("{")); !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("wanted os.ErrInvalid for invalid json, got %v", err)
+	}
+	docs := map[string]string{
+		"/docs/1": `{"tags": ["urgent"], "size": 10}`,
+		"/docs/2": `{"tags": ["later"], "size": 20}`,
+		"/docs/3": `{"tags": ["urgent", "later"], "size": 30}`,
+		"/raw/1":  `not json`,
+	}
+	for k, v := range docs {
+		if err := tx.Set(ctx, k, strings.NewReader(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query := func(beg, end, filter string) []string {
+		var keys []string
+		for k := range tx.Query(ctx, beg, end, filter, &err) {
+			keys = append(keys, k)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return keys
+	}
+
+	if keys := query("", "", `$.tags[*] ? (@ == "urgent")`); !reflect.DeepEqual(keys, []string{"/docs/1", "/docs/3"}) {
+		t.Fatalf("wanted [/docs/1 /docs/3], got %v", keys)
+	}
+	if keys := query("/docs/2", "", `$.size ? (@ > 15)`); !reflect.DeepEqual(keys, []string{"/docs/2", "/docs/3"}) {
+		t.Fatalf("wanted [/docs/2 /docs/3], got %v", keys)
+	}
+}
+
+func TestJSONKeyspaceSharedInstances(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	db1, err := New(ctx, dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db1.Close()
+
+	db2, err := Connect(ctx, dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db2.Close()
+
+	// Keyspace created through one instance must be enforced by the other.
+	if err := db1.CreateJSONKeyspace(ctx, "/docs/", true); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db2.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := tx.Set(ctx, "/docs/bad", strings.NewReader("{")); !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("wanted os.ErrInvalid for invalid json, got %v", err)
+	}
+	if err := tx.Set(ctx, "/docs/1", strings.NewReader(`{"size": 10}`)); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for k := range tx.Query(ctx, "", "", `$.size ? (@ > 5)`, &err) {
+		keys = append(keys, k)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, []string{"/docs/1"}) {
+		t.Fatalf("wanted [/docs/1], got %v", keys)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"fmt"
+	"os"
+)
+
+// DefaultMaxKeySize is the max key size when Options.MaxKeySize is zero.
+const DefaultMaxKeySize = 64 << 10
+
+// keyPrefixLen is the length of the key prefix in the ordered index on the
+// keys. Postgres btree indexes cannot hold large entries, so keys are
+// uniquely indexed by their sha256 hash and ordered by the indexed prefix
+// first and the full key next, which is same as the bytewise key order.
+const keyPrefixLen = 1024
+
+// keyPrefix is the indexed key prefix expression. It must match the
+// kv_key_prefix index definition.
+var keyPrefix = fmt.Sprintf("substring(key FROM 1 FOR %d)", keyPrefixLen)
+
+// paramPrefix returns the indexed prefix expression for the key parameter $n.
+func paramPrefix(n int) string {
+	return fmt.Sprintf("substring($%d::bytea FROM 1 FOR %d)", n, keyPrefixLen)
+}
+
+// KeyTooLongError is returned when a key is larger than the max key size.
+// See Options.MaxKeySize.
+type KeyTooLongError struct {
+	// Size is the size of the key.
+	Size int
+
+	// Max is the max key size.
+	Max int
+}
+
+func (e *KeyTooLongError) Error() string {
+	return fmt.Sprintf("key size %d exceeds the max key size %d", e.Size, e.Max)
+}
+
+func (e *KeyTooLongError) Unwrap() error {
+	return os.ErrInvalid
+}
+
+// keyOrder returns the ORDER BY clause items for the keys in the given
+// direction, which can be served by the kv_key_prefix index.
+func keyOrder(order string) string {
+	return keyPrefix + " " + order + ", key " + order
+}
+
+// checkKeySize returns a KeyTooLongError if the key is larger than the max
+// key size.
+func (d *Database) checkKeySize(k string) error {
+	if len(k) > d.maxKeySize {
+		return &KeyTooLongError{Size: len(k), Max: d.maxKeySize}
+	}
+	return nil
+}
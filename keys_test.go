@@ -0,0 +1,238 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// FuzzBinaryKeys applies random operations on binary keys to the database and
+// to an in-memory map, and verifies that both have the same keys and values
+// in the same bytewise order.
+//
+// Input is a sequence of operations, each encoded as an op byte, a key length
+// byte and the key bytes. Value of a key is derived from the key.
+func FuzzBinaryKeys(f *testing.F) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(f.TempDir(), "database")
+	f.Log("using database dir", dbDir)
+
+	db, err := New(ctx, dbDir)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Cleanup(func() { db.Close() })
+
+	f.Add([]byte("\x00\x01a\x00\x02ab\x01\x01a"))
+	f.Add([]byte("\x00\x03a\x00b\x00\x02a\xff\x00\x01\xff\x00\x02\xff\xff"))
+	f.Add([]byte("\x00\x02\\x\x00\x03\\x4\x00\x02\x80\x7f\x00\x01\x00\x02\x02\x01\x00"))
+	f.Add([]byte("\x00\x04\xffKV\x00\x02\x00\x00\x01\x02\x00\x00"))
+
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		model := make(map[string]string)
+
+		// Start from an empty database in every run.
+		tx, err := db.NewTransaction(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback(ctx)
+
+		for k := range tx.Ascend(ctx, "", "", &err) {
+			if err := tx.Delete(ctx, k); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ranges [][2]string
+		for len(ops) >= 2 {
+			op, n := ops[0], int(ops[1])
+			ops = ops[2:]
+			if n > len(ops) {
+				n = len(ops)
+			}
+			key := string(ops[:n])
+			ops = ops[n:]
+			if len(key) == 0 {
+				continue
+			}
+
+			switch op % 3 {
+			case 0:
+				value := key + "\x00value"
+				if err := tx.Set(ctx, key, bytes.NewReader([]byte(value))); err != nil {
+					t.Fatalf("set %q: %v", key, err)
+				}
+				model[key] = value
+			case 1:
+				if err := tx.Delete(ctx, key); err != nil && !errors.Is(err, os.ErrNotExist) {
+					t.Fatalf("delete %q: %v", key, err)
+				}
+				delete(model, key)
+			case 2:
+				ranges = append(ranges, [2]string{key, ""})
+				if len(ranges) > 1 {
+					ranges = append(ranges, [2]string{ranges[len(ranges)-2][0], key})
+				}
+			}
+		}
+		if err := tx.Commit(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		snap, err := db.NewSnapshot(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer snap.Discard(ctx)
+
+		for k, want := range model {
+			r, err := snap.Get(ctx, k)
+			if err != nil {
+				t.Fatalf("get %q: %v", k, err)
+			}
+			if got, _ := io.ReadAll(r); string(got) != want {
+				t.Fatalf("get %q: got %q, want %q", k, got, want)
+			}
+		}
+
+		keys := slices.Sorted(maps.Keys(model))
+		for _, r := range append(ranges, [2]string{"", ""}) {
+			beg, end := r[0], r[1]
+			if beg > end && end != "" {
+				continue
+			}
+
+			var want []string
+			for _, k := range keys {
+				if k >= beg && (end == "" || k < end) {
+					want = append(want, k)
+				}
+			}
+
+			var asc []string
+			for k, v := range snap.Ascend(ctx, beg, end, &err) {
+				if got, _ := io.ReadAll(v); string(got) != model[k] {
+					t.Fatalf("ascend %q: got value %q, want %q", k, got, model[k])
+				}
+				asc = append(asc, k)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(asc, want) {
+				t.Fatalf("ascend [%q, %q): got %q, want %q", beg, end, asc, want)
+			}
+
+			var desc []string
+			for k := range snap.Descend(ctx, beg, end, &err) {
+				desc = append(desc, k)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			slices.Reverse(want)
+			if !slices.Equal(desc, want) {
+				t.Fatalf("descend [%q, %q): got %q, want %q", beg, end, desc, want)
+			}
+		}
+	})
+}
+
+func TestLongKeys(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	db, err := NewWithOptions(ctx, dbDir, &Options{MaxKeySize: 16 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Keys share a prefix longer than the indexed key prefix.
+	prefix := strings.Repeat("p", 2*keyPrefixLen)
+	keys := []string{
+		prefix + strings.Repeat("a", 8<<10),
+		prefix + "b",
+		prefix + strings.Repeat("b", 4<<10),
+		prefix + "c",
+	}
+
+	tx, err := db.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	for _, k := range slices.Backward(keys) {
+		if err := tx.Set(ctx, k, strings.NewReader(k[len(prefix):len(prefix)+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Update of a long key must replace the existing value.
+	if err := tx.Set(ctx, keys[0], strings.NewReader("A")); err != nil {
+		t.Fatal(err)
+	}
+
+	var tooLong *KeyTooLongError
+	if err := tx.Set(ctx, strings.Repeat("x", 16<<10+1), strings.NewReader("x")); !errors.As(err, &tooLong) || !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("wanted KeyTooLongError, got %v", err)
+	}
+	if tooLong.Size != 16<<10+1 || tooLong.Max != 16<<10 {
+		t.Errorf("got %+v", tooLong)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := db.NewSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Discard(ctx)
+
+	r, err := snap.Get(ctx, keys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(r); string(data) != "A" {
+		t.Errorf("got %q, want %q", data, "A")
+	}
+
+	var asc []string
+	for k := range snap.Ascend(ctx, keys[0], "", &err) {
+		asc = append(asc, k)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(asc, keys) {
+		t.Errorf("ascend: got %d keys in wrong order", len(asc))
+	}
+
+	var desc []string
+	for k := range snap.Descend(ctx, keys[1], keys[3], &err) {
+		desc = append(desc, k)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(desc, []string{keys[2], keys[1]}) {
+		t.Errorf("descend: got %d keys in wrong order", len(desc))
+	}
+}
@@ -0,0 +1,322 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// lockRenewInterval is the interval at which the locks are verified on their
+// connections. A lock is reported as lost when the verification fails.
+const lockRenewInterval = time.Second
+
+var (
+	// ErrLocked is returned when a lock is held by another session.
+	ErrLocked = fmt.Errorf("lock is held by another session: %w", os.ErrExist)
+
+	// ErrLockLost is returned when a lock was released because its
+	// connection was lost or the database was closed.
+	ErrLockLost = fmt.Errorf("lock is lost: %w", os.ErrClosed)
+)
+
+// Lock is a session-level advisory lock, which is held on a dedicated
+// connection till it is unlocked. Postgres releases the lock automatically
+// when the connection is lost, e.g., when the process crashes, so the lock is
+// a lease that is renewed in the background by verifying the connection. See
+// Lock.Lost.
+//
+// Locks are identified by names, which are hashed to the two-key form of the
+// postgres advisory locks, so they do not conflict with the advisory locks
+// used internally by this package.
+type Lock struct {
+	db   *Database
+	name string
+	conn *sql.Conn
+
+	key1, key2 int32
+
+	stop chan struct{}
+	done chan struct{}
+	lost chan struct{}
+
+	finishOnce sync.Once
+	mu         sync.Mutex
+	err        error
+
+	// stopped is set by the first Unlock call, so that the background renewal
+	// is stopped only once. It is protected by mu.
+	stopped bool
+}
+
+// Lock acquires the named lock, waiting till it is available or the context
+// is canceled.
+func (d *Database) Lock(ctx context.Context, name string) (_ *Lock, status error) {
+	ctx, span := d.startSpan(ctx, "kvpostgres.Lock", Attribute{Key: AttrKey, Value: name})
+	defer func() { endSpan(span, status) }()
+
+	return d.newLock(ctx, name, false)
+}
+
+// TryLock is similar to Lock, but returns ErrLocked immediately if the lock
+// is held by another session.
+func (d *Database) TryLock(ctx context.Context, name string) (_ *Lock, status error) {
+	ctx, span := d.startSpan(ctx, "kvpostgres.TryLock", Attribute{Key: AttrKey, Value: name})
+	defer func() { endSpan(span, status) }()
+
+	return d.newLock(ctx, name, true)
+}
+
+func (d *Database) newLock(ctx context.Context, name string, try bool) (_ *Lock, status error) {
+	if len(name) == 0 {
+		return nil, os.ErrInvalid
+	}
+	// Locks are not active transactions, so they do not delay Close. They are
+	// lost when the database is closed. See Lock.keepalive.
+	d.mu.Lock()
+	closed := d.closed
+	d.mu.Unlock()
+	if closed {
+		return nil, os.ErrClosed
+	}
+
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if status != nil {
+			// Lock may be acquired when the wait is canceled concurrently, so
+			// the connection must not be reused with the lock.
+			conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock_all()")
+			conn.Close()
+		}
+	}()
+
+	key1, key2 := lockKeys(name)
+	if try {
+		var ok bool
+		if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1, $2)", key1, key2).Scan(&ok); err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrLocked
+		}
+	} else {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1, $2)", key1, key2); err != nil {
+			return nil, err
+		}
+	}
+
+	l := &Lock{
+		db:   d,
+		name: name,
+		conn: conn,
+		key1: key1,
+		key2: key2,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+		lost: make(chan struct{}),
+	}
+
+	// Database may be closed while the lock was acquired, in which case Close
+	// may be waiting for the background goroutines already.
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil, os.ErrClosed
+	}
+	d.wg.Add(1)
+	go l.keepalive()
+	return l, nil
+}
+
+// Name returns the lock name.
+func (l *Lock) Name() string {
+	return l.name
+}
+
+// Lost returns a channel that is closed when the lock is no longer held,
+// because it was unlocked, its connection was lost or the database was
+// closed. Err returns the reason after the channel is closed.
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Err returns nil while the lock is held, os.ErrClosed after the lock is
+// unlocked and ErrLockLost after the lock is lost.
+func (l *Lock) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// Renew verifies that the lock is still held by its connection. Locks are
+// renewed in the background, so explicit renewal is only necessary before
+// the critical operations. Lock is marked as lost if the verification fails.
+func (l *Lock) Renew(ctx context.Context) error {
+	if err := l.Err(); err != nil {
+		return err
+	}
+	if err := l.verify(ctx); err != nil {
+		slog.Warn("advisory lock is lost", "name", l.name, "err", err)
+		l.finish(ErrLockLost)
+		return ErrLockLost
+	}
+	return nil
+}
+
+// Unlock releases the lock. Returns ErrLockLost if the lock was already lost
+// and os.ErrClosed if it was already unlocked.
+func (l *Lock) Unlock(ctx context.Context) (status error) {
+	ctx, span := l.db.startSpan(ctx, "kvpostgres.Unlock", Attribute{Key: AttrKey, Value: l.name})
+	defer func() { endSpan(span, status) }()
+
+	l.mu.Lock()
+	if l.err != nil || l.stopped {
+		err := l.err
+		l.mu.Unlock()
+		if err == nil {
+			err = os.ErrClosed
+		}
+		return err
+	}
+	l.stopped = true
+	l.mu.Unlock()
+
+	close(l.stop)
+	<-l.done
+
+	// Background renewal may have lost the lock just before it was stopped.
+	if err := l.Err(); err != nil {
+		return err
+	}
+	var ok bool
+	err := l.conn.QueryRowContext(ctx, "SELECT pg_advisory_unlock($1, $2)", l.key1, l.key2).Scan(&ok)
+	if err == nil && !ok {
+		err = ErrLockLost
+	}
+	if err != nil {
+		l.finish(ErrLockLost)
+		return err
+	}
+	l.finish(os.ErrClosed)
+	return nil
+}
+
+// keepalive renews the lock periodically till it is unlocked or lost.
+func (l *Lock) keepalive() {
+	defer l.db.wg.Done()
+	defer close(l.done)
+
+	ticker := time.NewTicker(lockRenewInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-l.db.ctx.Done():
+			l.finish(ErrLockLost)
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(l.db.ctx, lockRenewInterval)
+			err := l.Renew(ctx)
+			cancel()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// verify checks that the lock is held by the connection.
+func (l *Lock) verify(ctx context.Context) error {
+	// Two-key advisory locks are reported with the keys as oids and objsubid 2.
+	q := "SELECT EXISTS (SELECT 1 FROM pg_locks WHERE locktype = 'advisory' AND pid = pg_backend_pid() AND classid = $1::bigint::oid AND objid = $2::bigint::oid AND objsubid = 2 AND granted)"
+	var held bool
+	if err := l.conn.QueryRowContext(ctx, q, int64(uint32(l.key1)), int64(uint32(l.key2))).Scan(&held); err != nil {
+		return err
+	}
+	if !held {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// finish releases the connection and closes the lost channel with the given
+// reason.
+func (l *Lock) finish(reason error) {
+	l.finishOnce.Do(func() {
+		l.mu.Lock()
+		l.err = reason
+		l.mu.Unlock()
+
+		// Closing the connection releases the session lock if it is still
+		// held, so the connection is never reused with the lock.
+		if reason != os.ErrClosed {
+			l.conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+		l.conn.Close()
+		close(l.lost)
+	})
+}
+
+// LockXact acquires the named lock till the end of the transaction, waiting
+// till it is available or the context is canceled. Transaction-level locks
+// are released automatically when the transaction is committed or rolled
+// back. Lock names are shared with the session-level locks.
+func (t *Transaction) LockXact(ctx context.Context, name string) (status error) {
+	ctx, span := t.db.startSpan(ctx, "kvpostgres.LockXact", Attribute{Key: AttrKey, Value: name})
+	defer func() { endSpan(span, status) }()
+
+	if t.tx == nil {
+		return os.ErrClosed
+	}
+	if len(name) == 0 {
+		return os.ErrInvalid
+	}
+	key1, key2 := lockKeys(name)
+	if _, err := t.tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1, $2)", key1, key2); err != nil {
+		return err
+	}
+	return nil
+}
+
+// TryLockXact is similar to LockXact, but returns ErrLocked immediately if
+// the lock is held by another session or transaction.
+func (t *Transaction) TryLockXact(ctx context.Context, name string) (status error) {
+	ctx, span := t.db.startSpan(ctx, "kvpostgres.TryLockXact", Attribute{Key: AttrKey, Value: name})
+	defer func() { endSpan(span, status) }()
+
+	if t.tx == nil {
+		return os.ErrClosed
+	}
+	if len(name) == 0 {
+		return os.ErrInvalid
+	}
+	key1, key2 := lockKeys(name)
+	var ok bool
+	if err := t.tx.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock($1, $2)", key1, key2).Scan(&ok); err != nil {
+		return err
+	}
+	if !ok {
+		return ErrLocked
+	}
+	return nil
+}
+
+// lockKeys returns the two advisory lock keys for a lock name.
+func lockKeys(name string) (int32, int32) {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	sum := h.Sum64()
+	return int32(sum >> 32), int32(sum)
+}
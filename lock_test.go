@@ -0,0 +1,160 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	db, err := New(ctx, dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	l, err := db.Lock(ctx, "leader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.TryLock(ctx, "leader"); !errors.Is(err, ErrLocked) {
+		t.Fatalf("wanted ErrLocked for a held lock, got %v", err)
+	}
+
+	// Lock waits are canceled with the context.
+	tctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	_, err = db.Lock(tctx, "leader")
+	cancel()
+	if err == nil {
+		t.Fatalf("wanted non-nil error for a canceled lock wait")
+	}
+
+	tx, err := db.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.TryLockXact(ctx, "leader"); !errors.Is(err, ErrLocked) {
+		t.Fatalf("wanted ErrLocked for a transaction lock, got %v", err)
+	}
+	if err := tx.LockXact(ctx, "other"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.TryLock(ctx, "other"); !errors.Is(err, ErrLocked) {
+		t.Fatalf("wanted ErrLocked for a lock held by a transaction, got %v", err)
+	}
+	tx.Rollback(ctx)
+
+	if err := l.Renew(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Unlock(ctx); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("wanted os.ErrClosed for a second unlock, got %v", err)
+	}
+	select {
+	case <-l.Lost():
+	default:
+		t.Errorf("wanted the lost channel to be closed after unlock")
+	}
+
+	// Concurrent unlocks release the lock only once.
+	l, err = db.TryLock(ctx, "leader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 4)
+	for i := 0; i < cap(errs); i++ {
+		go func() { errs <- l.Unlock(ctx) }()
+	}
+	var nunlocked int
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err == nil {
+			nunlocked++
+		} else if !errors.Is(err, os.ErrClosed) {
+			t.Errorf("wanted os.ErrClosed for a concurrent unlock, got %v", err)
+		}
+	}
+	if nunlocked != 1 {
+		t.Errorf("wanted exactly one unlock to succeed, got %d", nunlocked)
+	}
+
+	// Lock is reported as lost when its connection is terminated.
+	l, err = db.TryLock(ctx, "leader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pid int
+	if err := l.conn.QueryRowContext(ctx, "SELECT pg_backend_pid()").Scan(&pid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.db.ExecContext(ctx, "SELECT pg_terminate_backend($1)", pid); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-l.Lost():
+	case <-time.After(10 * lockRenewInterval):
+		t.Fatalf("lock is not reported as lost after the connection is terminated")
+	}
+	if !errors.Is(l.Err(), ErrLockLost) {
+		t.Errorf("wanted ErrLockLost, got %v", l.Err())
+	}
+
+	l2, err := db.TryLock(ctx, "leader")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Closing the database releases the locks.
+	db.Close()
+	select {
+	case <-l2.Lost():
+	default:
+		t.Errorf("wanted the lock to be lost after the database is closed")
+	}
+}
+
+func TestLockClose(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	db, err := NewWithOptions(ctx, dbDir, &Options{CloseTimeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	l, err := db.Lock(ctx, "leader")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Held locks are not active transactions, so Close must not wait for the
+	// close timeout.
+	start := time.Now()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("close waited %v with a lock held", d)
+	}
+	if !errors.Is(l.Err(), ErrLockLost) {
+		t.Errorf("wanted ErrLockLost after close, got %v", l.Err())
+	}
+	if _, err := db.Lock(ctx, "leader"); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("wanted os.ErrClosed for a lock after close, got %v", err)
+	}
+}
@@ -0,0 +1,23 @@
+// Copyright (c) 2025 Visvasity LLC
+
+//go:build !unix || solaris || aix
+
+package kvpostgres
+
+import "os"
+
+// lockShared is a no-op on platforms without flock support.
+func lockShared(f *os.File) error {
+	return nil
+}
+
+// tryLockExclusive always succeeds on platforms without flock support, so
+// the server is treated as used by this process only.
+func tryLockExclusive(f *os.File) (bool, error) {
+	return true, nil
+}
+
+// unlockFile is a no-op on platforms without flock support.
+func unlockFile(f *os.File) error {
+	return nil
+}
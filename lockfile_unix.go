@@ -0,0 +1,33 @@
+// Copyright (c) 2025 Visvasity LLC
+
+//go:build unix && !solaris && !aix
+
+package kvpostgres
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockShared takes a shared advisory lock on the file.
+func lockShared(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_SH)
+}
+
+// tryLockExclusive takes an exclusive advisory lock on the file without
+// blocking. Returns false if the file is locked by another process.
+func tryLockExclusive(f *os.File) (bool, error) {
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// unlockFile releases the advisory lock on the file.
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
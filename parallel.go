@@ -0,0 +1,154 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// samplesPerPartition is the approximate number of keys sampled per partition
+// to pick the partition boundaries.
+const samplesPerPartition = 100
+
+// ParallelScan calls fn for all key-value pairs in the given range using
+// multiple workers, which scan disjoint partitions of the range concurrently
+// on separate connections. Range semantics are same as Ascend.
+//
+// Partitions are balanced using a random sample of the keys in the range.
+// All workers share the snapshot exported from this transaction, so they
+// observe exactly the same view of the database, including the snapshot time
+// of the historical snapshots. Transaction must not have uncommitted changes.
+// See Transaction.ExportSnapshot.
+//
+// Callback fn is invoked concurrently from multiple goroutines, but keys in
+// each partition are visited in ascending order. First non-nil error from fn
+// stops the scan and is returned.
+func (t *Transaction) ParallelScan(ctx context.Context, beg, end string, workers int, fn func(key string, v io.Reader) error) (status error) {
+	ctx, span := t.db.startSpan(ctx, "kvpostgres.ParallelScan", Attribute{Key: AttrBegin, Value: beg}, Attribute{Key: AttrEnd, Value: end})
+	defer func() { endSpan(span, status) }()
+
+	if t.tx == nil {
+		return os.ErrClosed
+	}
+	if workers < 1 || fn == nil {
+		return os.ErrInvalid
+	}
+	if beg > end && end != "" {
+		return os.ErrInvalid
+	}
+	if len(t.writes) > 0 {
+		return fmt.Errorf("transaction has uncommitted changes: %w", os.ErrInvalid)
+	}
+
+	bounds, err := t.splitRange(ctx, beg, end, workers)
+	if err != nil {
+		return err
+	}
+	// Snapshots served by the replicas are exported and imported on the same
+	// replica.
+	id, err := t.exportSnapshot(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	edges := append(append([]string{beg}, bounds...), end)
+
+	var wg sync.WaitGroup
+	for i := 0; i+1 < len(edges); i++ {
+		wg.Add(1)
+		go func(pbeg, pend string) {
+			defer wg.Done()
+
+			if err := t.db.scanPartition(ctx, t.replica, id, t.asOf, pbeg, pend, fn); err != nil {
+				cancel(err)
+			}
+		}(edges[i], edges[i+1])
+	}
+	wg.Wait()
+
+	return context.Cause(ctx)
+}
+
+func (d *Database) scanPartition(ctx context.Context, r *replica, id string, asOf time.Time, beg, end string, fn func(string, io.Reader) error) (status error) {
+	snap, err := d.importSnapshot(ctx, r, id)
+	if err != nil {
+		return err
+	}
+	defer snap.Discard(ctx)
+	snap.asOf = asOf
+
+	for k, v := range snap.Ascend(ctx, beg, end, &status) {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return status
+}
+
+// splitRange returns up to n-1 keys in increasing order that split the range
+// into n partitions of roughly equal number of keys.
+func (t *Transaction) splitRange(ctx context.Context, beg, end string, n int) ([]string, error) {
+	if n < 2 {
+		return nil, nil
+	}
+
+	var reltuples float64
+	if err := t.tx.QueryRowContext(ctx, "SELECT reltuples FROM pg_class WHERE oid = 'kv'::regclass").Scan(&reltuples); err != nil {
+		return nil, err
+	}
+	// Table statistics can be unknown (negative) before the first analyze.
+	percent := 100.0
+	if reltuples > 0 {
+		percent = min(100, 100*float64(n*samplesPerPartition)/reltuples)
+	}
+
+	fractions := make([]float64, 0, n-1)
+	for i := 1; i < n; i++ {
+		fractions = append(fractions, float64(i)/float64(n))
+	}
+
+	cond, args := keyRange(beg, end, 2)
+	args = append([]any{pq.Array(fractions)}, args...)
+
+	sample := fmt.Sprintf("TABLESAMPLE BERNOULLI (%f)", percent)
+	froms := []string{"kv " + sample, "kv"}
+	if !t.asOf.IsZero() {
+		// Historical view cannot be sampled, so exact boundaries are computed.
+		froms, percent = []string{t.source()}, 100
+	}
+	for _, from := range froms {
+		q := "SELECT percentile_disc($1::float8[]) WITHIN GROUP (ORDER BY key) FROM " + from + " WHERE " + cond
+		var keys pq.ByteaArray
+		if err := t.tx.QueryRowContext(ctx, q, args...).Scan(&keys); err != nil {
+			return nil, err
+		}
+
+		var bounds []string
+		for _, k := range keys {
+			key := string(k)
+			if len(key) == 0 || key <= beg || (end != "" && key >= end) {
+				continue
+			}
+			if len(bounds) > 0 && key <= bounds[len(bounds)-1] {
+				continue
+			}
+			bounds = append(bounds, key)
+		}
+		// Sample may be too small when the range is a tiny fraction of the
+		// table, in which case, exact boundaries are computed over the range.
+		if len(bounds) > 0 || percent == 100 {
+			return bounds, nil
+		}
+	}
+	return nil, nil
+}
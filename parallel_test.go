@@ -0,0 +1,79 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParallelScan(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	db, err := New(ctx, dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("/key%04d", i)
+		if err := tx.Set(ctx, key, strings.NewReader(key)); err != nil {
+			t.Fatal(err)
+		}
+		if i >= 100 && i < 900 {
+			want = append(want, key)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := db.NewSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Discard(ctx)
+
+	var mu sync.Mutex
+	var got []string
+	err = snap.ParallelScan(ctx, "/key0100", "/key0900", 4, func(k string, v io.Reader) error {
+		data, err := io.ReadAll(v)
+		if err != nil {
+			return err
+		}
+		if string(data) != k {
+			return fmt.Errorf("key %q has unexpected value %q", k, data)
+		}
+		mu.Lock()
+		got = append(got, k)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Fatalf("wanted %d keys, got %d keys", len(want), len(got))
+	}
+
+	stop := fmt.Errorf("stop")
+	if err := snap.ParallelScan(ctx, "", "", 4, func(string, io.Reader) error { return stop }); err != stop {
+		t.Fatalf("wanted callback error, got %v", err)
+	}
+}
@@ -3,15 +3,18 @@
 package kvpostgres
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"io"
 	"iter"
+	"log/slog"
 	"os"
 	"path/filepath"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/lib/pq"
@@ -19,19 +22,143 @@ import (
 
 const defaultDB = "kvs"
 
+// Options holds optional configuration for the database.
+type Options struct {
+	// Tracer, when non-nil, receives spans for transactions and queries.
+	Tracer Tracer
+
+	// CloseTimeout is the grace period for active transactions to finish when
+	// the database is closed. Transactions that are still active after the
+	// grace period are canceled. Zero value cancels them immediately.
+	CloseTimeout time.Duration
+
+	// Versioned, when true, keeps all committed versions of the keys in a
+	// history table, which enables Database.NewSnapshotAsOf and
+	// Transaction.History. Versioned mode should be used consistently by all
+	// users of a database.
+	Versioned bool
+
+	// HistoryRetention, when positive, is the duration for which the older
+	// versions are kept in the versioned mode. Versions that are superseded
+	// before the retention period are pruned in the background.
+	HistoryRetention time.Duration
+
+	// ChangeFeed, when true, creates a logical replication slot for the
+	// key-value store, which enables Database.Changes. Postgres server must be
+	// configured with wal_level=logical, which is the default for the data
+	// directories initialized by this package. Note that the changes are
+	// retained by the server till they are acknowledged by Database.AckChanges.
+	ChangeFeed bool
+
+	// Compression configures compression of the values for key prefixes.
+	// Values are decoded transparently irrespective of the current settings,
+	// as long as their codecs are known.
+	Compression []Compression
+
+	// Codecs holds additional codecs that are only used to decode the values,
+	// e.g., codecs that were used in the past. GzipCodec is always known.
+	Codecs []Codec
+
+	// KeyProvider, when non-nil, enables encryption of the values with the
+	// master keys from the provider. It cannot be used with the databases that
+	// have JSON keyspaces. See KeyProvider for details.
+	KeyProvider KeyProvider
+
+	// Replicas holds the data directories of hot standbys that serve the
+	// snapshots created by Database.NewSnapshot. Snapshots fall back to the
+	// primary when no replica is available. Replica snapshots use the
+	// repeatable read isolation level, may not observe the latest commits and
+	// cannot be exported. See StartStandby.
+	Replicas []string
+
+	// MaxReplicaLag, when positive, is the max replication lag of a replica
+	// for serving snapshots.
+	MaxReplicaLag time.Duration
+
+	// MaxKeySize is the max size of the keys in bytes. Transaction.Set returns
+	// a KeyTooLongError for larger keys. Zero value uses DefaultMaxKeySize.
+	// Note that the secondary index keys are limited to about 2KB by the
+	// postgres btree index irrespective of this setting.
+	MaxKeySize int
+}
+
 type Database struct {
 	db    *sql.DB
 	stopf func()
+
+	tracer Tracer
+
+	versioned bool
+	retention time.Duration
+
+	// indexes holds the secondary indexes registered with the database. It is
+	// replaced, not modified, on updates and is guarded by the mu.
+	indexes map[string]Extractor
+
+	// jsonPrefixes holds the key prefixes of the JSON keyspaces. It is
+	// replaced, not modified, on updates and is guarded by the mu.
+	jsonPrefixes []string
+
+	changeFeed bool
+
+	codecs      map[byte]Codec
+	compression []Compression
+
+	keys KeyProvider
+
+	maxKeySize int
+
+	replicas      []*replica
+	maxReplicaLag time.Duration
+	nextReplica   atomic.Uint64
+
+	// ctx is canceled when the database is closed. All transactions are
+	// created with this context, so canceling it rolls them back.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	closeTimeout time.Duration
+	closeOnce    sync.Once
+	closeErr     error
+
+	// wg tracks the background goroutines.
+	wg sync.WaitGroup
+
+	mu      sync.Mutex
+	closed  bool
+	active  int
+	drained chan struct{}
 }
 
 type Transaction struct {
 	db *Database
 	tx *sql.Tx
+
+	// writes holds the keys modified by the transaction. Value is true for
+	// the keys that are set and false for the keys that are deleted.
+	writes map[string]bool
+
+	// revision is the commit revision of a committed read-write transaction.
+	revision int64
+
+	// asOf is the time of the historical snapshot; it is zero for all other
+	// transactions.
+	asOf time.Time
+
+	// replica is the replica that serves the snapshot; it is nil for all
+	// other transactions.
+	replica *replica
 }
 
 // New creates a key-value store (if it doesn't exist) backed by a private
 // postgres instance.
-func New(ctx context.Context, dataDir string) (_ *Database, status error) {
+func New(ctx context.Context, dataDir string) (*Database, error) {
+	return NewWithOptions(ctx, dataDir, nil)
+}
+
+// NewWithOptions is similar to New, but also takes optional configuration
+// for the database. A nil opts is equivalent to the zero Options.
+func NewWithOptions(ctx context.Context, dataDir string, opts *Options) (_ *Database, status error) {
 	if !filepath.IsAbs(dataDir) {
 		absDir, err := filepath.Abs(dataDir)
 		if err != nil {
@@ -44,33 +171,36 @@ func New(ctx context.Context, dataDir string) (_ *Database, status error) {
 	if err != nil {
 		return nil, err
 	}
-
-	cs := fmt.Sprintf("user=postgres dbname=%s host=%s", defaultDB, dataDir)
-	connector, err := pq.NewConnector(cs)
-	if err != nil {
-		return nil, err
-	}
-	db := sql.OpenDB(connector)
 	defer func() {
 		if status != nil {
-			_ = db.Close()
+			stopf()
 		}
 	}()
 
-	q := fmt.Sprintf("CREATE TABLE IF NOT EXISTS kv (key BYTEA PRIMARY KEY, value BYTEA)")
-	if _, err := db.ExecContext(ctx, q); err != nil {
-		return nil, err
-	}
-	d := &Database{
-		db:    db,
-		stopf: stopf,
-	}
-	return d, nil
+	return open(ctx, dataDir, stopf, opts)
 }
 
 // Connect creates a db instance using an already running database server at
 // the given directory.
-func Connect(ctx context.Context, dataDir string) (_ *Database, status error) {
+func Connect(ctx context.Context, dataDir string) (*Database, error) {
+	return ConnectWithOptions(ctx, dataDir, nil)
+}
+
+// ConnectWithOptions is similar to Connect, but also takes optional
+// configuration for the database. A nil opts is equivalent to the zero
+// Options.
+func ConnectWithOptions(ctx context.Context, dataDir string, opts *Options) (*Database, error) {
+	return open(ctx, dataDir, nil, opts)
+}
+
+func open(ctx context.Context, dataDir string, stopf func(), opts *Options) (_ *Database, status error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+	if err := checkCodecs(opts.Compression, opts.Codecs); err != nil {
+		return nil, err
+	}
+
 	cs := fmt.Sprintf("user=postgres dbname=%s host=%s", defaultDB, dataDir)
 	connector, err := pq.NewConnector(cs)
 	if err != nil {
@@ -83,42 +213,143 @@ func Connect(ctx context.Context, dataDir string) (_ *Database, status error) {
 		}
 	}()
 
-	q := fmt.Sprintf("CREATE TABLE IF NOT EXISTS kv (key BYTEA PRIMARY KEY, value BYTEA)")
-	if _, err := db.ExecContext(ctx, q); err != nil {
+	if err := migrate(ctx, db); err != nil {
+		return nil, err
+	}
+	if opts.ChangeFeed {
+		if err := setupChangeFeed(ctx, db); err != nil {
+			return nil, err
+		}
+	}
+	jsonPrefixes, err := loadJSONKeyspaces(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	if len(jsonPrefixes) > 0 && opts.KeyProvider != nil {
+		return nil, errJSONEncrypted
+	}
+	replicas, err := openReplicas(opts.Replicas)
+	if err != nil {
 		return nil, err
 	}
+
+	dbctx, cancel := context.WithCancel(context.Background())
 	d := &Database{
-		db: db,
+		db:           db,
+		stopf:        stopf,
+		ctx:          dbctx,
+		cancel:       cancel,
+		tracer:       opts.Tracer,
+		closeTimeout: opts.CloseTimeout,
+		versioned:    opts.Versioned,
+		retention:    opts.HistoryRetention,
+		changeFeed:   opts.ChangeFeed,
+		keys:         opts.KeyProvider,
+		jsonPrefixes: jsonPrefixes,
+
+		maxKeySize: opts.MaxKeySize,
+
+		replicas:      replicas,
+		maxReplicaLag: opts.MaxReplicaLag,
+	}
+	if d.maxKeySize <= 0 {
+		d.maxKeySize = DefaultMaxKeySize
+	}
+	d.setCodecs(opts.Compression, opts.Codecs)
+
+	if d.versioned && d.retention > 0 {
+		d.wg.Add(1)
+		go d.pruneHistoryLoop()
 	}
 	return d, nil
 }
 
-// Close shuts down the postgres database server.
+// Close waits for the active transactions to finish for up to
+// Options.CloseTimeout duration, cancels the remaining transactions, releases
+// the connection pool and stops the postgres server if it was started by this
+// instance. All later NewTransaction and NewSnapshot calls return
+// os.ErrClosed. Close is safe to call multiple times and concurrently.
 func (d *Database) Close() error {
+	d.closeOnce.Do(func() {
+		d.closeErr = d.close()
+	})
+	return d.closeErr
+}
+
+func (d *Database) close() error {
+	d.mu.Lock()
+	d.closed = true
+	var drained chan struct{}
+	if d.active > 0 {
+		drained = make(chan struct{})
+		d.drained = drained
+	}
+	d.mu.Unlock()
+
+	if drained != nil && d.closeTimeout > 0 {
+		timer := time.NewTimer(d.closeTimeout)
+		select {
+		case <-drained:
+		case <-timer.C:
+			slog.Warn("canceling active transactions after close timeout", "timeout", d.closeTimeout)
+		}
+		timer.Stop()
+	}
+
+	// Canceling the context rolls back all remaining transactions and stops
+	// the background goroutines.
+	d.cancel()
+	d.wg.Wait()
+
+	err := d.db.Close()
+	for _, r := range d.replicas {
+		r.db.Close()
+	}
 	if d.stopf != nil {
 		d.stopf()
 		d.stopf = nil
 	}
-	return nil
+	return err
 }
 
-// NewSnapshot creates a read-only snapshot of the key-value database.
-func (d *Database) NewSnapshot(ctx context.Context) (*Transaction, error) {
-	tx, err := d.db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelSerializable, ReadOnly: true})
-	if err != nil {
-		return nil, err
+// acquire registers a new active transaction or returns os.ErrClosed if the
+// database is closed.
+func (d *Database) acquire() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return os.ErrClosed
 	}
+	d.active++
+	return nil
+}
 
-	if _, err := tx.ExecContext(ctx, "SET LOCAL lock_timeout = '1s'"); err != nil {
-		tx.Rollback()
-		return nil, err
+// release unregisters an active transaction.
+func (d *Database) release() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.active--
+	if d.active == 0 && d.drained != nil {
+		close(d.drained)
+		d.drained = nil
 	}
+}
 
-	s := &Transaction{
-		db: d,
-		tx: tx,
+// NewSnapshot creates a read-only snapshot of the key-value database.
+// Snapshots are served by the replicas when they are configured. See
+// Options.Replicas.
+func (d *Database) NewSnapshot(ctx context.Context) (_ *Transaction, status error) {
+	ctx, span := d.startSpan(ctx, "kvpostgres.NewSnapshot")
+	defer func() { endSpan(span, status) }()
+
+	if len(d.replicas) > 0 {
+		if t := d.newReplicaSnapshot(ctx, span); t != nil {
+			return t, nil
+		}
 	}
-	return s, nil
+	return d.begin(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable, ReadOnly: true})
 }
 
 // Discard releases a snapshot.
@@ -127,17 +358,42 @@ func (t *Transaction) Discard(ctx context.Context) error {
 }
 
 // NewTransaction creates a new transaction.
-func (d *Database) NewTransaction(ctx context.Context) (*Transaction, error) {
-	tx, err := d.db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelSerializable})
-	if err != nil {
+func (d *Database) NewTransaction(ctx context.Context) (_ *Transaction, status error) {
+	ctx, span := d.startSpan(ctx, "kvpostgres.NewTransaction")
+	defer func() { endSpan(span, status) }()
+
+	return d.begin(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+}
+
+// begin starts a new postgres transaction and runs the optional setup
+// statements at the start of the transaction.
+func (d *Database) begin(ctx context.Context, opts *sql.TxOptions, setup ...string) (*Transaction, error) {
+	return d.beginOn(ctx, d.db, opts, setup...)
+}
+
+// beginOn is similar to begin, but uses the given connection pool.
+func (d *Database) beginOn(ctx context.Context, db *sql.DB, opts *sql.TxOptions, setup ...string) (_ *Transaction, status error) {
+	if err := d.acquire(); err != nil {
 		return nil, err
 	}
+	defer func() {
+		if status != nil {
+			d.release()
+		}
+	}()
 
-	if _, err := tx.ExecContext(ctx, "SET LOCAL lock_timeout = '1s'"); err != nil {
-		tx.Rollback()
+	tx, err := db.BeginTx(d.ctx, opts)
+	if err != nil {
 		return nil, err
 	}
 
+	for _, q := range append(setup, "SET LOCAL lock_timeout = '1s'") {
+		if _, err := tx.ExecContext(ctx, q); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
 	t := &Transaction{
 		db: d,
 		tx: tx,
@@ -146,20 +402,42 @@ func (d *Database) NewTransaction(ctx context.Context) (*Transaction, error) {
 }
 
 // Commit commits a transaction.
-func (t *Transaction) Commit(ctx context.Context) error {
+func (t *Transaction) Commit(ctx context.Context) (status error) {
+	ctx, span := t.db.startSpan(ctx, "kvpostgres.Commit")
+	defer func() { endSpan(span, status) }()
+
 	if t.tx == nil {
 		return os.ErrClosed
 	}
 	defer func() {
 		t.tx = nil
+		t.db.release()
 	}()
 
+	var revision int64
+	if len(t.writes) > 0 {
+		rev, err := t.stampRevision(ctx)
+		if err != nil {
+			t.tx.Rollback()
+			return fmt.Errorf("could not commit transaction: %w", err)
+		}
+		revision = rev
+	}
+
 	if err := t.tx.Commit(); err != nil {
 		return fmt.Errorf("could not commit transaction: %w", err)
 	}
+	t.revision = revision
 	return nil
 }
 
+func (t *Transaction) setWrite(k string, set bool) {
+	if t.writes == nil {
+		t.writes = make(map[string]bool)
+	}
+	t.writes[k] = set
+}
+
 // Rollback drops a transaction.
 func (t *Transaction) Rollback(ctx context.Context) error {
 	if t.tx == nil {
@@ -167,16 +445,24 @@ func (t *Transaction) Rollback(ctx context.Context) error {
 	}
 	defer func() {
 		t.tx = nil
+		t.db.release()
 	}()
 
 	if err := t.tx.Rollback(); err != nil {
+		if errors.Is(err, sql.ErrTxDone) {
+			// Transaction was canceled by Database.Close.
+			return os.ErrClosed
+		}
 		return fmt.Errorf("could not rollback transaction: %w", err)
 	}
 	return nil
 }
 
 // Get returns the value for a given key.
-func (t *Transaction) Get(ctx context.Context, k string) (io.Reader, error) {
+func (t *Transaction) Get(ctx context.Context, k string) (_ io.Reader, status error) {
+	ctx, span := t.db.startSpan(ctx, "kvpostgres.Get", Attribute{Key: AttrKey, Value: k})
+	defer func() { endSpan(span, status) }()
+
 	if t.tx == nil {
 		return nil, os.ErrClosed
 	}
@@ -184,49 +470,103 @@ func (t *Transaction) Get(ctx context.Context, k string) (io.Reader, error) {
 		return nil, os.ErrInvalid
 	}
 
-	q := "SELECT value FROM kv WHERE key = $1"
-	row := t.tx.QueryRowContext(ctx, q, k)
+	q := "SELECT value FROM " + t.source() + " WHERE key = $1"
+	row := t.tx.QueryRowContext(ctx, q, []byte(k))
 
-	var v string
-	if err := row.Scan(&v); err != nil {
+	var data []byte
+	if err := row.Scan(&data); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, os.ErrNotExist
 		}
 		return nil, err
 	}
-	return strings.NewReader(v), nil
+	v, err := t.db.decodeValue(ctx, k, data)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(v), nil
 }
 
 // Set creates or updates a key-value pair.
-func (t *Transaction) Set(ctx context.Context, k string, v io.Reader) error {
+func (t *Transaction) Set(ctx context.Context, k string, v io.Reader) (status error) {
+	ctx, span := t.db.startSpan(ctx, "kvpostgres.Set", Attribute{Key: AttrKey, Value: k})
+	defer func() { endSpan(span, status) }()
+
 	if t.tx == nil {
 		return os.ErrClosed
 	}
 	if v == nil || len(k) == 0 {
 		return os.ErrInvalid
 	}
+	if err := t.db.checkKeySize(k); err != nil {
+		return err
+	}
 	s, err := io.ReadAll(v)
 	if err != nil {
 		return err
 	}
-	q := `INSERT INTO kv (key, value) VALUES ($1, $2) ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value;`
-	if _, err := t.tx.ExecContext(ctx, q, k, s); err != nil {
+	data, err := t.db.encodeValue(ctx, k, s)
+	if err != nil {
+		return err
+	}
+	if t.db.versioned {
+		if err := t.recordBaseline(ctx, k); err != nil {
+			return err
+		}
+	}
+	// Key is written only when the JSON keyspaces of the key known to this
+	// instance are same as in the transaction. Keyspaces are only added, so
+	// they are compared by the count and reloaded on a mismatch.
+	q := `INSERT INTO kv (key, value, doc) SELECT $1, $2, $3::jsonb WHERE (SELECT count(*) FROM kv_keyspaces WHERE kind = 'json' AND substring($1 FROM 1 FOR length(prefix)) = prefix) = $4 ON CONFLICT ((sha256(key))) DO UPDATE SET value = EXCLUDED.value, doc = EXCLUDED.doc;`
+	for reloaded := false; ; reloaded = true {
+		doc, nkeyspaces, err := t.db.jsonDoc(k, s)
+		if err != nil {
+			return err
+		}
+		result, err := t.tx.ExecContext(ctx, q, []byte(k), data, doc, nkeyspaces)
+		if err != nil {
+			return err
+		}
+		nrows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if nrows > 0 {
+			break
+		}
+		if reloaded {
+			return fmt.Errorf("JSON keyspaces of key %q changed during the transaction: %w", k, os.ErrInvalid)
+		}
+		if err := t.reloadJSONKeyspaces(ctx); err != nil {
+			return err
+		}
+	}
+	if err := t.updateIndexes(ctx, k, s); err != nil {
 		return err
 	}
+	t.setWrite(k, true)
 	return nil
 }
 
 // Delete removes a key-value pair.
-func (t *Transaction) Delete(ctx context.Context, k string) error {
+func (t *Transaction) Delete(ctx context.Context, k string) (status error) {
+	ctx, span := t.db.startSpan(ctx, "kvpostgres.Delete", Attribute{Key: AttrKey, Value: k})
+	defer func() { endSpan(span, status) }()
+
 	if t.tx == nil {
 		return os.ErrClosed
 	}
 	if len(k) == 0 {
 		return os.ErrInvalid
 	}
+	if t.db.versioned {
+		if err := t.recordBaseline(ctx, k); err != nil {
+			return err
+		}
+	}
 
 	q := "DELETE FROM kv WHERE key = $1"
-	result, err := t.tx.ExecContext(ctx, q, k)
+	result, err := t.tx.ExecContext(ctx, q, []byte(k))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return os.ErrNotExist
@@ -244,73 +584,35 @@ func (t *Transaction) Delete(ctx context.Context, k string) error {
 	if nrows == 0 {
 		return os.ErrNotExist
 	}
+	if err := t.updateIndexes(ctx, k, nil); err != nil {
+		return err
+	}
+	t.setWrite(k, false)
 	return nil
 }
 
+// Ascend returns key-value pairs in a given range, in ascending order.
 func (t *Transaction) Ascend(ctx context.Context, beg, end string, errp *error) iter.Seq2[string, io.Reader] {
 	return func(yield func(string, io.Reader) bool) {
-		if t.tx == nil {
-			*errp = os.ErrClosed
-			return
-		}
-		if beg > end && end != "" {
-			*errp = os.ErrInvalid
-			return
-		}
-
-		// NOTE: Cursor name cannot be passed as a value parameter using $1 syntax.
-		name := fmt.Sprintf("c%d", time.Now().UnixNano())
-		cursorPrefix := fmt.Sprintf("DECLARE %s CURSOR FOR ", name)
-
-		switch {
-		case beg == "" && end == "":
-			q := cursorPrefix + "SELECT key, value FROM kv ORDER BY key ASC"
-			if _, err := t.tx.ExecContext(ctx, q); err != nil {
-				*errp = err
-				return
-			}
-
-		case beg != "" && end != "":
-			q := cursorPrefix + "SELECT key, value FROM kv WHERE key >= $1 AND key < $2 ORDER BY key ASC"
-			if _, err := t.tx.ExecContext(ctx, q, beg, end); err != nil {
-				*errp = err
-				return
-			}
-
-		case beg == "" && end != "":
-			q := cursorPrefix + "SELECT key, value FROM kv WHERE key < $1 ORDER BY key ASC"
-			if _, err := t.tx.ExecContext(ctx, q, end); err != nil {
-				*errp = err
-				return
-			}
-
-		case beg != "" && end == "":
-			q := cursorPrefix + "SELECT key, value FROM kv WHERE key >= $1 ORDER BY key ASC"
-			if _, err := t.tx.ExecContext(ctx, q, beg); err != nil {
-				*errp = err
-				return
-			}
-		}
-		defer t.tx.Exec("CLOSE " + name)
+		ctx, span := t.db.startSpan(ctx, "kvpostgres.Ascend", Attribute{Key: AttrBegin, Value: beg}, Attribute{Key: AttrEnd, Value: end})
+		defer func() { endSpan(span, *errp) }()
 
-		for {
-			var key, value string
-			if err := t.tx.QueryRowContext(ctx, `FETCH NEXT FROM `+name).Scan(&key, &value); err != nil {
-				if err == sql.ErrNoRows {
-					break
-				}
-				*errp = err
-				return
-			}
-			if !yield(key, strings.NewReader(value)) {
-				return
-			}
-		}
+		t.scan(ctx, beg, end, "ASC", errp)(yield)
 	}
 }
 
 // Descend returns key-value pairs in a given range, in descending order.
 func (t *Transaction) Descend(ctx context.Context, beg, end string, errp *error) iter.Seq2[string, io.Reader] {
+	return func(yield func(string, io.Reader) bool) {
+		ctx, span := t.db.startSpan(ctx, "kvpostgres.Descend", Attribute{Key: AttrBegin, Value: beg}, Attribute{Key: AttrEnd, Value: end})
+		defer func() { endSpan(span, *errp) }()
+
+		t.scan(ctx, beg, end, "DESC", errp)(yield)
+	}
+}
+
+// scan returns key-value pairs in a given range in the given key order.
+func (t *Transaction) scan(ctx context.Context, beg, end, order string, errp *error) iter.Seq2[string, io.Reader] {
 	return func(yield func(string, io.Reader) bool) {
 		if t.tx == nil {
 			*errp = os.ErrClosed
@@ -321,53 +623,72 @@ func (t *Transaction) Descend(ctx context.Context, beg, end string, errp *error)
 			return
 		}
 
+		cond, args := keyRange(beg, end, 1)
+		q := "SELECT key, value FROM " + t.source() + " WHERE " + cond + " ORDER BY " + keyOrder(order)
+		t.cursor(ctx, q, args, errp)(yield)
+	}
+}
+
+// cursor runs the query through a server-side cursor and returns the
+// key-value pairs fetched one row at a time.
+func (t *Transaction) cursor(ctx context.Context, query string, args []any, errp *error) iter.Seq2[string, io.Reader] {
+	return func(yield func(string, io.Reader) bool) {
 		// NOTE: Cursor name cannot be passed as a value parameter using $1 syntax.
 		name := fmt.Sprintf("c%d", time.Now().UnixNano())
-		cursorPrefix := fmt.Sprintf("DECLARE %s CURSOR FOR ", name)
-
-		switch {
-		case beg == "" && end == "":
-			q := cursorPrefix + "SELECT key, value FROM kv ORDER BY key DESC"
-			if _, err := t.tx.ExecContext(ctx, q); err != nil {
-				*errp = err
-				return
-			}
-
-		case beg != "" && end != "":
-			q := cursorPrefix + "SELECT key, value FROM kv WHERE key >= $1 AND key < $2 ORDER BY key DESC"
-			if _, err := t.tx.ExecContext(ctx, q, beg, end); err != nil {
-				*errp = err
-				return
-			}
-
-		case beg == "" && end != "":
-			q := cursorPrefix + "SELECT key, value FROM kv WHERE key < $1 ORDER BY key DESC"
-			if _, err := t.tx.ExecContext(ctx, q, end); err != nil {
-				*errp = err
-				return
-			}
-
-		case beg != "" && end == "":
-			q := cursorPrefix + "SELECT key, value FROM kv WHERE key >= $1 ORDER BY key DESC"
-			if _, err := t.tx.ExecContext(ctx, q, beg); err != nil {
-				*errp = err
-				return
-			}
+		if _, err := t.tx.ExecContext(ctx, fmt.Sprintf("DECLARE %s CURSOR FOR %s", name, query), args...); err != nil {
+			*errp = err
+			return
 		}
 		defer t.tx.Exec("CLOSE " + name)
 
 		for {
-			var key, value string
-			if err := t.tx.QueryRowContext(ctx, `FETCH NEXT FROM `+name).Scan(&key, &value); err != nil {
+			var key string
+			var data []byte
+			if err := t.tx.QueryRowContext(ctx, `FETCH NEXT FROM `+name).Scan(&key, &data); err != nil {
 				if err == sql.ErrNoRows {
 					break
 				}
 				*errp = err
 				return
 			}
-			if !yield(key, strings.NewReader(value)) {
+			value, err := t.db.decodeValue(ctx, key, data)
+			if err != nil {
+				*errp = err
+				return
+			}
+			if !yield(key, bytes.NewReader(value)) {
 				return
 			}
 		}
 	}
 }
+
+// keyRange returns the SQL condition and arguments that select the keys in
+// the given range. Argument placeholders in the condition start at $n.
+func keyRange(beg, end string, n int) (string, []any) {
+	cond, args := columnRange("key", beg, end, n)
+	// Implied conditions on the key prefix enable the kv_key_prefix index.
+	switch {
+	case beg != "" && end != "":
+		cond += " AND " + keyPrefix + " >= " + paramPrefix(n) + " AND " + keyPrefix + " <= " + paramPrefix(n+1)
+	case beg == "" && end != "":
+		cond += " AND " + keyPrefix + " <= " + paramPrefix(n)
+	case beg != "" && end == "":
+		cond += " AND " + keyPrefix + " >= " + paramPrefix(n)
+	}
+	return cond, args
+}
+
+// columnRange is similar to keyRange, but for the given column.
+func columnRange(column, beg, end string, n int) (string, []any) {
+	switch {
+	case beg != "" && end != "":
+		return fmt.Sprintf("%s >= $%d AND %s < $%d", column, n, column, n+1), []any{[]byte(beg), []byte(end)}
+	case beg == "" && end != "":
+		return fmt.Sprintf("%s < $%d", column, n), []any{[]byte(end)}
+	case beg != "" && end == "":
+		return fmt.Sprintf("%s >= $%d", column, n), []any{[]byte(beg)}
+	default:
+		return "TRUE", nil
+	}
+}
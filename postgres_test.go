@@ -4,11 +4,15 @@ package kvpostgres
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestNew(t *testing.T) {
@@ -92,3 +96,74 @@ func TestBasic(t *testing.T) {
 
 	t.Logf("%s", valueBytes)
 }
+
+func TestClose(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	db, err := NewWithOptions(ctx, dbDir, &Options{CloseTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Active transaction must be allowed to commit within the grace period.
+	tx, err := db.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Set(ctx, "/key", strings.NewReader("value")); err != nil {
+		t.Fatal(err)
+	}
+	commitErr := make(chan error, 1)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		commitErr <- tx.Commit(ctx)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := db.Close(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := <-commitErr; err != nil {
+		t.Fatalf("wanted commit to succeed within the grace period, got %v", err)
+	}
+	if _, err := db.NewTransaction(ctx); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("wanted os.ErrClosed, got %v", err)
+	}
+	if _, err := db.NewSnapshot(ctx); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("wanted os.ErrClosed, got %v", err)
+	}
+}
+
+func TestCloseCancelsTransactions(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	db, err := New(ctx, dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(ctx); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("wanted os.ErrClosed for canceled transaction, got %v", err)
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"time"
+)
+
+// DefaultMaxAttempts is the number of deliveries of a job before it is moved
+// to the dead letters when Queue.MaxAttempts is zero.
+const DefaultMaxAttempts = 5
+
+// Queue is a durable work queue stored in the same database as the keys.
+// Jobs are delivered to one consumer at a time and are redelivered if they
+// are not acknowledged within the visibility timeout. Jobs that are not
+// acknowledged after Queue.MaxAttempts deliveries are moved to the dead
+// letters.
+//
+// Consumers lock the jobs with FOR UPDATE SKIP LOCKED in short read
+// committed transactions, so concurrent consumers do not block each other
+// or cause serialization failures.
+type Queue struct {
+	db   *Database
+	name string
+
+	// MaxAttempts is the max number of deliveries of a job. Zero value uses
+	// DefaultMaxAttempts.
+	MaxAttempts int
+}
+
+// EnqueueOptions holds the optional parameters for the new jobs.
+type EnqueueOptions struct {
+	// Delay is the duration after which the job is available to consumers.
+	Delay time.Duration
+
+	// Priority of the job. Jobs with higher priority are delivered first and
+	// jobs with the same priority are delivered in the order they are
+	// available.
+	Priority int
+}
+
+// Job is a job delivered from a queue.
+type Job struct {
+	ID       int64
+	Payload  []byte
+	Priority int
+
+	// Attempts is the number of deliveries of the job, including this one.
+	Attempts int
+
+	// CreatedAt is the time when the job was enqueued.
+	CreatedAt time.Time
+}
+
+// Queue returns the work queue with the given name. Queues are created
+// implicitly by enqueuing jobs.
+func (d *Database) Queue(name string) *Queue {
+	return &Queue{db: d, name: name}
+}
+
+// Name returns the queue name.
+func (q *Queue) Name() string {
+	return q.name
+}
+
+func (q *Queue) maxAttempts() int {
+	if q.MaxAttempts <= 0 {
+		return DefaultMaxAttempts
+	}
+	return q.MaxAttempts
+}
+
+// Enqueue adds a job to the queue as part of the transaction, so the job is
+// available to consumers only if the transaction is committed, which makes it
+// suitable as a transactional outbox for the key-value changes. Returns the
+// job id.
+func (q *Queue) Enqueue(ctx context.Context, tx *Transaction, payload []byte, opts *EnqueueOptions) (_ int64, status error) {
+	ctx, span := q.db.startSpan(ctx, "kvpostgres.Enqueue", Attribute{Key: AttrKey, Value: q.name})
+	defer func() { endSpan(span, status) }()
+
+	if tx == nil || payload == nil || len(q.name) == 0 {
+		return 0, os.ErrInvalid
+	}
+	if tx.tx == nil {
+		return 0, os.ErrClosed
+	}
+	if opts == nil {
+		opts = new(EnqueueOptions)
+	}
+	if opts.Delay < 0 {
+		return 0, os.ErrInvalid
+	}
+
+	var id int64
+	sq := "INSERT INTO kv_queue (queue, payload, priority, visible_at) VALUES ($1, $2, $3, now() + $4 * interval '1 microsecond') RETURNING id"
+	if err := tx.tx.QueryRowContext(ctx, sq, q.name, payload, opts.Priority, opts.Delay.Microseconds()).Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// Dequeue delivers the next available job from the queue, which is hidden
+// from other consumers for the visibility timeout. Job must be acknowledged
+// with Ack or Nack before the timeout, otherwise, it is redelivered. Returns
+// os.ErrNotExist if no job is available.
+func (q *Queue) Dequeue(ctx context.Context, visibilityTimeout time.Duration) (_ *Job, status error) {
+	ctx, span := q.db.startSpan(ctx, "kvpostgres.Dequeue", Attribute{Key: AttrKey, Value: q.name})
+	defer func() { endSpan(span, status) }()
+
+	if visibilityTimeout <= 0 {
+		return nil, os.ErrInvalid
+	}
+
+	tx, err := q.db.begin(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Jobs whose last delivery has timed out are moved to the dead letters.
+	dq := "UPDATE kv_queue SET dead = TRUE WHERE id IN (SELECT id FROM kv_queue WHERE queue = $1 AND NOT dead AND visible_at <= now() AND attempts >= $2 FOR UPDATE SKIP LOCKED)"
+	if _, err := tx.tx.ExecContext(ctx, dq, q.name, q.maxAttempts()); err != nil {
+		return nil, err
+	}
+
+	sq := "UPDATE kv_queue SET attempts = attempts + 1, visible_at = now() + $2 * interval '1 microsecond'" +
+		" WHERE id = (SELECT id FROM kv_queue WHERE queue = $1 AND NOT dead AND visible_at <= now() ORDER BY priority DESC, visible_at, id LIMIT 1 FOR UPDATE SKIP LOCKED)" +
+		" RETURNING id, payload, priority, attempts, created_at"
+	job := new(Job)
+	if err := tx.tx.QueryRowContext(ctx, sq, q.name, visibilityTimeout.Microseconds()).Scan(&job.ID, &job.Payload, &job.Priority, &job.Attempts, &job.CreatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			if err := tx.Commit(ctx); err != nil {
+				return nil, err
+			}
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Ack removes a delivered job from the queue after it is processed. Returns
+// os.ErrNotExist if the job was redelivered after its visibility timeout or
+// was already acknowledged.
+func (q *Queue) Ack(ctx context.Context, job *Job) (status error) {
+	ctx, span := q.db.startSpan(ctx, "kvpostgres.Ack", Attribute{Key: AttrKey, Value: q.name})
+	defer func() { endSpan(span, status) }()
+
+	if job == nil {
+		return os.ErrInvalid
+	}
+	result, err := q.db.db.ExecContext(ctx, "DELETE FROM kv_queue WHERE id = $1 AND queue = $2 AND attempts = $3 AND NOT dead", job.ID, q.name, job.Attempts)
+	if err != nil {
+		return err
+	}
+	return checkJobUpdated(result)
+}
+
+// Nack returns a delivered job to the queue after the given delay, so that
+// it is redelivered, or moves it to the dead letters if it has reached the
+// max attempts. Returns os.ErrNotExist if the job was redelivered after its
+// visibility timeout or was already acknowledged.
+func (q *Queue) Nack(ctx context.Context, job *Job, delay time.Duration) (status error) {
+	ctx, span := q.db.startSpan(ctx, "kvpostgres.Nack", Attribute{Key: AttrKey, Value: q.name})
+	defer func() { endSpan(span, status) }()
+
+	if job == nil || delay < 0 {
+		return os.ErrInvalid
+	}
+	uq := "UPDATE kv_queue SET visible_at = now() + $4 * interval '1 microsecond', dead = attempts >= $5" +
+		" WHERE id = $1 AND queue = $2 AND attempts = $3 AND NOT dead"
+	result, err := q.db.db.ExecContext(ctx, uq, job.ID, q.name, job.Attempts, delay.Microseconds(), q.maxAttempts())
+	if err != nil {
+		return err
+	}
+	return checkJobUpdated(result)
+}
+
+// DeadLetters returns up to limit jobs from the dead letters of the queue, in
+// the order they were enqueued.
+func (q *Queue) DeadLetters(ctx context.Context, limit int) (_ []*Job, status error) {
+	ctx, span := q.db.startSpan(ctx, "kvpostgres.DeadLetters", Attribute{Key: AttrKey, Value: q.name})
+	defer func() { endSpan(span, status) }()
+
+	if limit <= 0 {
+		return nil, os.ErrInvalid
+	}
+	sq := "SELECT id, payload, priority, attempts, created_at FROM kv_queue WHERE queue = $1 AND dead ORDER BY id LIMIT $2"
+	rows, err := q.db.db.QueryContext(ctx, sq, q.name, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*Job
+	for rows.Next() {
+		job := new(Job)
+		if err := rows.Scan(&job.ID, &job.Payload, &job.Priority, &job.Attempts, &job.CreatedAt); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// Requeue moves a job from the dead letters back to the queue with its
+// attempts reset. Returns os.ErrNotExist if the job is not a dead letter.
+func (q *Queue) Requeue(ctx context.Context, id int64) (status error) {
+	ctx, span := q.db.startSpan(ctx, "kvpostgres.Requeue", Attribute{Key: AttrKey, Value: q.name})
+	defer func() { endSpan(span, status) }()
+
+	uq := "UPDATE kv_queue SET dead = FALSE, attempts = 0, visible_at = now() WHERE id = $1 AND queue = $2 AND dead"
+	result, err := q.db.db.ExecContext(ctx, uq, id, q.name)
+	if err != nil {
+		return err
+	}
+	return checkJobUpdated(result)
+}
+
+// checkJobUpdated returns os.ErrNotExist if no job was updated.
+func checkJobUpdated(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return os.ErrNotExist
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQueue(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	db, err := New(ctx, dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	q := db.Queue("jobs")
+	q.MaxAttempts = 2
+
+	enqueue := func(payload string, opts *EnqueueOptions, commit bool) {
+		t.Helper()
+		tx, err := db.NewTransaction(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback(ctx)
+
+		if err := tx.Set(ctx, "/outbox/"+payload, strings.NewReader(payload)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := q.Enqueue(ctx, tx, []byte(payload), opts); err != nil {
+			t.Fatal(err)
+		}
+		if commit {
+			if err := tx.Commit(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Jobs of rolled back transactions are never delivered.
+	enqueue("rolled-back", nil, false)
+	if _, err := q.Dequeue(ctx, time.Minute); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("wanted os.ErrNotExist for an empty queue, got %v", err)
+	}
+
+	enqueue("low", nil, true)
+	enqueue("high", &EnqueueOptions{Priority: 10}, true)
+	enqueue("delayed", &EnqueueOptions{Delay: time.Hour, Priority: 100}, true)
+
+	job, err := q.Dequeue(ctx, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(job.Payload) != "high" || job.Attempts != 1 {
+		t.Fatalf("got job %q with %d attempts, want high with 1 attempt", job.Payload, job.Attempts)
+	}
+	if err := q.Ack(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Ack(ctx, job); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("wanted os.ErrNotExist for a second ack, got %v", err)
+	}
+
+	// Unacknowledged jobs are redelivered after the visibility timeout.
+	job, err = q.Dequeue(ctx, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(job.Payload) != "low" {
+		t.Fatalf("got job %q, want low", job.Payload)
+	}
+	if _, err := q.Dequeue(ctx, time.Minute); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("wanted os.ErrNotExist while the job is invisible, got %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	redelivered, err := q.Dequeue(ctx, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redelivered.ID != job.ID || redelivered.Attempts != 2 {
+		t.Fatalf("got job %d with %d attempts, want job %d with 2 attempts", redelivered.ID, redelivered.Attempts, job.ID)
+	}
+	if err := q.Ack(ctx, job); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("wanted os.ErrNotExist for an ack of a stale delivery, got %v", err)
+	}
+
+	// Jobs are dead-lettered after the max attempts.
+	if err := q.Nack(ctx, redelivered, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Dequeue(ctx, time.Minute); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("wanted os.ErrNotExist after the job is dead-lettered, got %v", err)
+	}
+	dead, err := q.DeadLetters(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].ID != job.ID {
+		t.Fatalf("got %d dead letters, want job %d", len(dead), job.ID)
+	}
+	if err := q.Requeue(ctx, job.ID); err != nil {
+		t.Fatal(err)
+	}
+	job, err = q.Dequeue(ctx, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Attempts != 1 {
+		t.Errorf("got %d attempts after requeue, want 1", job.Attempts)
+	}
+	if err := q.Ack(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueueConcurrentConsumers(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	db, err := New(ctx, dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	q := db.Queue("jobs")
+
+	tx, err := db.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	const njobs = 100
+	for i := 0; i < njobs; i++ {
+		if _, err := q.Enqueue(ctx, tx, []byte(fmt.Sprintf("job-%d", i)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	seen := make(map[int64]bool)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				job, err := q.Dequeue(ctx, time.Minute)
+				if err != nil {
+					if !errors.Is(err, os.ErrNotExist) {
+						t.Error(err)
+					}
+					return
+				}
+				mu.Lock()
+				if seen[job.ID] {
+					t.Errorf("job %d is delivered twice", job.ID)
+				}
+				seen[job.ID] = true
+				mu.Unlock()
+
+				if err := q.Ack(ctx, job); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(seen) != njobs {
+		t.Errorf("got %d jobs delivered, want %d", len(seen), njobs)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// replica is a read-only connection pool to a hot standby server.
+type replica struct {
+	dataDir string
+	db      *sql.DB
+}
+
+// AttrReplica is the span attribute with the data directory of the replica
+// that serves a snapshot.
+const AttrReplica = "kv.replica"
+
+func openReplicas(dataDirs []string) ([]*replica, error) {
+	var replicas []*replica
+	for _, dir := range dataDirs {
+		cs := fmt.Sprintf("user=postgres dbname=%s host=%s", defaultDB, dir)
+		connector, err := pq.NewConnector(cs)
+		if err != nil {
+			for _, r := range replicas {
+				r.db.Close()
+			}
+			return nil, err
+		}
+		replicas = append(replicas, &replica{dataDir: dir, db: sql.OpenDB(connector)})
+	}
+	return replicas, nil
+}
+
+// replicaLagQuery returns the replication lag of a standby in seconds. Lag is
+// zero when all received changes are replayed, because the replay timestamp
+// doesn't advance when the primary is idle, but only while the standby is
+// streaming from the primary; received changes are stale otherwise. Lag is
+// null when the standby is not streaming or nothing is replayed yet.
+// Promoted standbys are not lagging.
+const replicaLagQuery = `SELECT CASE
+	WHEN NOT pg_is_in_recovery() THEN 0
+	WHEN NOT EXISTS (SELECT 1 FROM pg_stat_wal_receiver WHERE status = 'streaming') THEN NULL
+	WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	ELSE EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())
+END`
+
+// newReplicaSnapshot creates a snapshot on one of the replicas that are within
+// the max replication lag. Replicas are tried in a round-robin order. Returns
+// nil if no replica is available.
+func (d *Database) newReplicaSnapshot(ctx context.Context, span Span) *Transaction {
+	start := int(d.nextReplica.Add(1))
+	for i := range d.replicas {
+		r := d.replicas[(start+i)%len(d.replicas)]
+		t, err := d.beginReplica(ctx, r)
+		if err != nil {
+			slog.Debug("could not use replica for the snapshot", "replica", r.dataDir, "err", err)
+			continue
+		}
+		span.SetAttributes(Attribute{Key: AttrReplica, Value: r.dataDir})
+		return t
+	}
+	return nil
+}
+
+// beginReplica starts a read-only transaction on a replica and verifies that
+// its snapshot is within the max replication lag.
+func (d *Database) beginReplica(ctx context.Context, r *replica) (_ *Transaction, status error) {
+	// Hot standbys do not support the serializable isolation level.
+	t, err := d.beginOn(ctx, r.db, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if status != nil {
+			t.Rollback(ctx)
+		}
+	}()
+	t.replica = r
+
+	if d.maxReplicaLag > 0 {
+		var lag sql.NullFloat64
+		if err := t.tx.QueryRowContext(ctx, replicaLagQuery).Scan(&lag); err != nil {
+			return nil, err
+		}
+		if !lag.Valid {
+			return nil, fmt.Errorf("replica is not streaming from the primary")
+		}
+		if time.Duration(lag.Float64*float64(time.Second)) > d.maxReplicaLag {
+			return nil, fmt.Errorf("replica lag %v exceeds the limit %v", lag.Float64, d.maxReplicaLag)
+		}
+	}
+	return t, nil
+}
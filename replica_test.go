@@ -0,0 +1,146 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplicaSnapshots(t *testing.T) {
+	ctx := context.Background()
+
+	tmpDir := t.TempDir()
+	primaryDir := filepath.Join(tmpDir, "primary")
+	standbyDir := filepath.Join(tmpDir, "standby")
+	t.Log("using database dirs", primaryDir, standbyDir)
+
+	primary, err := New(ctx, primaryDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+
+	standby, err := StartStandby(ctx, primaryDir, standbyDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer standby.Stop()
+
+	tracer := new(testTracer)
+	opts := &Options{
+		Tracer:        tracer,
+		Replicas:      []string{standbyDir},
+		MaxReplicaLag: time.Minute,
+	}
+	db, err := ConnectWithOptions(ctx, primaryDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Set(ctx, "/a", strings.NewReader("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replica eventually observes the commit.
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		snap, err := db.NewSnapshot(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if snap.replica == nil {
+			t.Fatalf("wanted the snapshot to be served by the replica")
+		}
+		r, err := snap.Get(ctx, "/a")
+		snap.Discard(ctx)
+		if err == nil {
+			if data, _ := io.ReadAll(r); string(data) != "a" {
+				t.Fatalf("got %q, want %q", data, "a")
+			}
+			break
+		}
+		if !errors.Is(err, os.ErrNotExist) || time.Now().After(deadline) {
+			t.Fatalf("change is not visible on the replica: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if s := tracer.find("kvpostgres.NewSnapshot"); s == nil || s.attrs[AttrReplica] != standbyDir {
+		t.Errorf("wanted snapshot span with the replica attribute, got %+v", s)
+	}
+
+	snap, err := db.NewSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := snap.ExportSnapshot(ctx); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("wanted os.ErrInvalid for exporting a replica snapshot, got %v", err)
+	}
+	var n int
+	if err := snap.ParallelScan(ctx, "", "", 2, func(string, io.Reader) error { n++; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("parallel scan on the replica: got %d keys, want 1", n)
+	}
+	snap.Discard(ctx)
+
+	// Snapshots are not served by a replica that is disconnected from the
+	// primary, even when it has replayed all received changes.
+	r := db.replicas[0]
+	for _, q := range []string{"ALTER SYSTEM SET primary_conninfo = ''", "SELECT pg_reload_conf()"} {
+		if _, err := r.db.ExecContext(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline = time.Now().Add(10 * time.Second)
+	for {
+		var streaming bool
+		if err := r.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_stat_wal_receiver WHERE status = 'streaming')").Scan(&streaming); err != nil {
+			t.Fatal(err)
+		}
+		if !streaming {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("replica is still streaming from the primary")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	snap, err = db.NewSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.replica != nil {
+		t.Errorf("wanted the snapshot to be served by the primary when the replica is disconnected")
+	}
+	snap.Discard(ctx)
+
+	// Snapshots fall back to the primary when the replica is down.
+	standby.Stop()
+	snap, err = db.NewSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Discard(ctx)
+	if snap.replica != nil {
+		t.Fatalf("wanted the snapshot to be served by the primary")
+	}
+	if _, err := snap.Get(ctx, "/a"); err != nil {
+		t.Fatal(err)
+	}
+}
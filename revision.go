@@ -0,0 +1,124 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// revisionLockID is the advisory lock that serializes revision assignment
+// across concurrent commits, so that revisions increase in the commit order.
+const revisionLockID = 0x6b762d7265760001
+
+// revisionWaitTimeout is the maximum time CurrentRevision waits for the
+// in-progress commits.
+const revisionWaitTimeout = 10 * time.Second
+
+// CommitRevision returns the revision assigned to the transaction when it is
+// committed. Every successful commit of a read-write transaction that has
+// modified the database is stamped with a unique, monotonically increasing
+// revision, which is also stored with the keys it has set. Returns zero for
+// uncommitted or read-only transactions.
+func (t *Transaction) CommitRevision() int64 {
+	return t.revision
+}
+
+// CurrentRevision returns the latest revision assigned to a commit, such that
+// all commits with smaller revisions are complete. Revisions may have gaps
+// because commits can fail after a revision is assigned. CurrentRevision
+// waits for the in-progress commits for up to 10 seconds.
+func (d *Database) CurrentRevision(ctx context.Context) (_ int64, status error) {
+	ctx, span := d.startSpan(ctx, "kvpostgres.CurrentRevision")
+	defer func() { endSpan(span, status) }()
+
+	tx, err := d.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Wait for the in-progress commits that hold the revision lock, so that
+	// returned revision is not ahead of the committed data.
+	timeout := fmt.Sprintf("SET LOCAL lock_timeout = %d", revisionWaitTimeout.Milliseconds())
+	if _, err := tx.ExecContext(ctx, timeout); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock_shared($1)", int64(revisionLockID)); err != nil {
+		return 0, err
+	}
+
+	var revision int64
+	var called bool
+	if err := tx.QueryRowContext(ctx, "SELECT last_value, is_called FROM kv_revision").Scan(&revision, &called); err != nil {
+		return 0, err
+	}
+	if !called {
+		return 0, nil
+	}
+
+	// Prepared transactions of the sharded databases are not complete till
+	// they are resolved, so the revision is kept below their revisions, which
+	// are the last component of their names. See Transaction.prepare.
+	var prepared sql.NullInt64
+	q := `SELECT min(NULLIF(split_part(gid, ':', 4), '')::bigint) FROM pg_prepared_xacts WHERE database = current_database() AND gid LIKE 'kvpostgres:%'`
+	if err := tx.QueryRowContext(ctx, q).Scan(&prepared); err != nil {
+		return 0, err
+	}
+	if prepared.Valid && prepared.Int64 <= revision {
+		revision = prepared.Int64 - 1
+	}
+	return revision, nil
+}
+
+// stampRevision assigns a new revision to the transaction and records it with
+// all keys set by the transaction. Revision lock is held till the end of the
+// transaction.
+func (t *Transaction) stampRevision(ctx context.Context) (int64, error) {
+	// Commits wait for the earlier commits without the lock timeout, because
+	// they hold the revision lock while stamping all of their keys.
+	if _, err := t.tx.ExecContext(ctx, "SET LOCAL lock_timeout = 0"); err != nil {
+		return 0, err
+	}
+	if _, err := t.tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", int64(revisionLockID)); err != nil {
+		return 0, err
+	}
+	return t.assignRevision(ctx)
+}
+
+// assignRevision assigns a new revision to the transaction and records it
+// with all keys set by the transaction. Revision lock must be held by the
+// caller.
+func (t *Transaction) assignRevision(ctx context.Context) (int64, error) {
+
+	var revision int64
+	var timestamp time.Time
+	if err := t.tx.QueryRowContext(ctx, "SELECT nextval('kv_revision'), clock_timestamp()").Scan(&revision, &timestamp); err != nil {
+		return 0, err
+	}
+
+	var keys, deletes [][]byte
+	for k, set := range t.writes {
+		if set {
+			keys = append(keys, []byte(k))
+		} else {
+			deletes = append(deletes, []byte(k))
+		}
+	}
+	if len(keys) > 0 {
+		q := "UPDATE kv SET revision = $1 WHERE key = ANY($2)"
+		if _, err := t.tx.ExecContext(ctx, q, revision, pq.ByteaArray(keys)); err != nil {
+			return 0, err
+		}
+	}
+	if t.db.versioned {
+		if err := t.recordHistory(ctx, revision, timestamp, keys, deletes); err != nil {
+			return 0, err
+		}
+	}
+	return revision, nil
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCommitRevision(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	db, err := New(ctx, dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var last int64
+	for _, key := range []string{"/a", "/b", "/c"} {
+		tx, err := db.NewTransaction(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Set(ctx, key, strings.NewReader(key)); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			t.Fatal(err)
+		}
+		rev := tx.CommitRevision()
+		if rev <= last {
+			t.Fatalf("wanted revision greater than %d, got %d", last, rev)
+		}
+		last = rev
+
+		current, err := db.CurrentRevision(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if current != rev {
+			t.Fatalf("wanted current revision %d, got %d", rev, current)
+		}
+	}
+
+	// Transactions without changes do not get a revision.
+	tx, err := db.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if rev := tx.CommitRevision(); rev != 0 {
+		t.Fatalf("wanted zero revision for empty transaction, got %d", rev)
+	}
+}
+
+func TestRevisionLockWait(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	db, err := New(ctx, dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	set := func(keys ...string) error {
+		tx, err := db.NewTransaction(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+		for _, k := range keys {
+			if err := tx.Set(ctx, k, strings.NewReader(k)); err != nil {
+				return err
+			}
+		}
+		return tx.Commit(ctx)
+	}
+
+	// Small commits must wait for a large commit that holds the revision lock
+	// for longer than the lock timeout.
+	var large []string
+	for i := 0; i < 50000; i++ {
+		large = append(large, fmt.Sprintf("/large/%06d", i))
+	}
+	hold, err := db.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hold.tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", int64(revisionLockID)); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 9)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		errs <- set(large...)
+	}()
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- set(fmt.Sprintf("/small/%d", i))
+		}()
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+	if err := hold.Rollback(ctx); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("wanted concurrent commits to succeed, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// DefaultScanLimit is the max number of key-value pairs in a page when
+// ScanOptions.Limit is zero.
+const DefaultScanLimit = 1000
+
+// ScanOptions holds the parameters for a paginated range scan.
+type ScanOptions struct {
+	// Beg and End define the key range with the same semantics as Ascend.
+	Beg, End string
+
+	// Reverse, when true, returns the keys in descending order.
+	Reverse bool
+
+	// Limit is the max number of key-value pairs in a page. Zero value uses
+	// DefaultScanLimit.
+	Limit int
+
+	// MaxBytes, when positive, is the max total size of the keys and values
+	// in a page. A page always has at least one key-value pair when the
+	// range is not exhausted, even if it is larger than MaxBytes.
+	MaxBytes int
+
+	// PageToken is the continuation token from the previous page. It must be
+	// used with the same range and direction as the previous page.
+	PageToken string
+}
+
+// KeyValue is a key-value pair.
+type KeyValue struct {
+	Key   string
+	Value []byte
+}
+
+// ScanResult is a page of key-value pairs from a paginated range scan.
+type ScanResult struct {
+	// Items holds the key-value pairs in the scan order.
+	Items []*KeyValue
+
+	// NextPageToken is the continuation token for the next page. It is empty
+	// when the range is exhausted.
+	NextPageToken string
+}
+
+// scanTokenVersion is the first byte of the page tokens.
+const scanTokenVersion = 1
+
+// Scan returns a page of key-value pairs in a range and a continuation token
+// for the next page. Pages are selected by the last key of the previous page
+// instead of an offset, so a scan can be resumed in a later transaction and
+// observes the keys committed in between that are after the last key.
+func (t *Transaction) Scan(ctx context.Context, opts ScanOptions) (_ *ScanResult, status error) {
+	ctx, span := t.db.startSpan(ctx, "kvpostgres.Scan", Attribute{Key: AttrBegin, Value: opts.Beg}, Attribute{Key: AttrEnd, Value: opts.End})
+	defer func() { endSpan(span, status) }()
+
+	if t.tx == nil {
+		return nil, os.ErrClosed
+	}
+	if opts.Beg > opts.End && opts.End != "" {
+		return nil, os.ErrInvalid
+	}
+	if opts.Limit < 0 || opts.MaxBytes < 0 {
+		return nil, os.ErrInvalid
+	}
+	limit := opts.Limit
+	if limit == 0 {
+		limit = DefaultScanLimit
+	}
+
+	// Resume the range after (or before) the last key of the previous page.
+	beg, end := opts.Beg, opts.End
+	if len(opts.PageToken) > 0 {
+		last, err := parseScanToken(&opts)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Reverse {
+			end = last
+		} else {
+			beg = last + "\x00"
+		}
+		if end != "" && beg >= end {
+			return new(ScanResult), nil
+		}
+	}
+
+	order := "ASC"
+	if opts.Reverse {
+		order = "DESC"
+	}
+	cond, args := keyRange(beg, end, 1)
+	q := "SELECT key, value FROM " + t.source() + " WHERE " + cond + " ORDER BY " + keyOrder(order) + " LIMIT " + strconv.Itoa(limit+1)
+	rows, err := t.tx.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := new(ScanResult)
+	more, size := false, 0
+	for rows.Next() {
+		if len(result.Items) == limit {
+			more = true
+			break
+		}
+		var key, data []byte
+		if err := rows.Scan(&key, &data); err != nil {
+			return nil, err
+		}
+		value, err := t.db.decodeValue(ctx, string(key), data)
+		if err != nil {
+			return nil, err
+		}
+		if opts.MaxBytes > 0 && len(result.Items) > 0 && size+len(key)+len(value) > opts.MaxBytes {
+			more = true
+			break
+		}
+		size += len(key) + len(value)
+		result.Items = append(result.Items, &KeyValue{Key: string(key), Value: value})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if more {
+		result.NextPageToken = newScanToken(&opts, result.Items[len(result.Items)-1].Key)
+	}
+	return result, nil
+}
+
+// scanFingerprint returns a hash of the scan range and direction, which ties
+// the page tokens to the scan parameters.
+func scanFingerprint(opts *ScanOptions) []byte {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:%s%d:%s%t", len(opts.Beg), opts.Beg, len(opts.End), opts.End, opts.Reverse)
+	return h.Sum(nil)[:8]
+}
+
+// newScanToken returns a page token with the version, the scan fingerprint
+// and the last key of the page.
+func newScanToken(opts *ScanOptions, last string) string {
+	token := append([]byte{scanTokenVersion}, scanFingerprint(opts)...)
+	token = append(token, last...)
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+// parseScanToken returns the last key of the previous page from a page token.
+func parseScanToken(opts *ScanOptions) (string, error) {
+	token, err := base64.RawURLEncoding.DecodeString(opts.PageToken)
+	if err != nil || len(token) < 10 || token[0] != scanTokenVersion {
+		return "", fmt.Errorf("malformed page token: %w", os.ErrInvalid)
+	}
+	if !bytes.Equal(token[1:9], scanFingerprint(opts)) {
+		return "", fmt.Errorf("page token is from a different scan: %w", os.ErrInvalid)
+	}
+	return string(token[9:]), nil
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestScanToken(t *testing.T) {
+	opts := &ScanOptions{Beg: "/a", End: "/b"}
+	token := newScanToken(opts, "/a/\x00\xff")
+
+	opts.PageToken = token
+	if last, err := parseScanToken(opts); err != nil || last != "/a/\x00\xff" {
+		t.Errorf("got %q, %v, want the last key", last, err)
+	}
+
+	for _, other := range []*ScanOptions{
+		{Beg: "/a", End: "/c", PageToken: token},
+		{Beg: "/a", End: "/b", Reverse: true, PageToken: token},
+		{Beg: "/a", End: "/b", PageToken: "!!"},
+		{Beg: "/a", End: "/b", PageToken: token[:4]},
+	} {
+		if _, err := parseScanToken(other); !errors.Is(err, os.ErrInvalid) {
+			t.Errorf("%+v: wanted os.ErrInvalid, got %v", other, err)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	db, err := New(ctx, dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	var keys []string
+	for i := 0; i < 25; i++ {
+		k := fmt.Sprintf("/k/%02d", i)
+		if err := tx.Set(ctx, k, strings.NewReader("value")); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, k)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every page is read in a separate snapshot.
+	scanAll := func(opts ScanOptions) (pages int, got []string) {
+		for {
+			snap, err := db.NewSnapshot(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := snap.Scan(ctx, opts)
+			snap.Discard(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pages++
+			for _, item := range result.Items {
+				if string(item.Value) != "value" {
+					t.Errorf("key %q: got value %q", item.Key, item.Value)
+				}
+				got = append(got, item.Key)
+			}
+			if result.NextPageToken == "" {
+				return pages, got
+			}
+			opts.PageToken = result.NextPageToken
+		}
+	}
+
+	if pages, got := scanAll(ScanOptions{Beg: "/k/", End: "/k0", Limit: 10}); pages != 3 || !slices.Equal(got, keys) {
+		t.Errorf("ascending scan: got %d pages with %q", pages, got)
+	}
+
+	want := slices.Clone(keys[5:20])
+	slices.Reverse(want)
+	if pages, got := scanAll(ScanOptions{Beg: keys[5], End: keys[20], Reverse: true, Limit: 4}); pages != 4 || !slices.Equal(got, want) {
+		t.Errorf("descending scan: got %d pages with %q", pages, got)
+	}
+
+	// Each key-value pair is 10 bytes.
+	if pages, got := scanAll(ScanOptions{MaxBytes: 25}); pages != 13 || !slices.Equal(got, keys) {
+		t.Errorf("size limited scan: got %d pages with %q", pages, got)
+	}
+	if pages, got := scanAll(ScanOptions{MaxBytes: 1}); pages != 25 || !slices.Equal(got, keys) {
+		t.Errorf("scan with a tiny size limit: got %d pages with %q", pages, got)
+	}
+
+	snap, err := db.NewSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Discard(ctx)
+
+	if _, err := snap.Scan(ctx, ScanOptions{Beg: "/b", End: "/a"}); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("wanted os.ErrInvalid for an invalid range, got %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+)
+
+// schemaLockID is the advisory lock that serializes schema migrations across
+// concurrent opens of the same database.
+const schemaLockID = 0x6b762d7363680001
+
+// migrations holds the ordered schema changes of the key-value store, where
+// migrations[i] upgrades the schema from version i to version i+1. Released
+// migrations must never be modified or reordered; new changes are appended.
+//
+// Databases created before the schema was versioned have no recorded version
+// and are upgraded from version zero, so the statements of the migrations up
+// to version 6 must be idempotent.
+var migrations = [][]string{
+	// Version 1: Key-value table.
+	{
+		`CREATE TABLE IF NOT EXISTS kv (key BYTEA PRIMARY KEY, value BYTEA)`,
+	},
+
+	// Version 2: Commit revisions. See revision.go.
+	{
+		`CREATE SEQUENCE IF NOT EXISTS kv_revision`,
+		`ALTER TABLE kv ADD COLUMN IF NOT EXISTS revision BIGINT`,
+	},
+
+	// Version 3: Versioned mode. See history.go.
+	{
+		`CREATE TABLE IF NOT EXISTS kv_history (key BYTEA, revision BIGINT, committed_at TIMESTAMPTZ NOT NULL, value BYTEA, deleted BOOLEAN NOT NULL, PRIMARY KEY (key, revision))`,
+		`CREATE INDEX IF NOT EXISTS kv_history_committed_at ON kv_history (committed_at)`,
+	},
+
+	// Version 4: Secondary indexes. See index.go.
+	{
+		`CREATE TABLE IF NOT EXISTS kv_index (name TEXT, ikey BYTEA, key BYTEA, PRIMARY KEY (name, ikey, key))`,
+		`CREATE INDEX IF NOT EXISTS kv_index_key ON kv_index (key)`,
+	},
+
+	// Version 5: JSON keyspaces. See json.go.
+	{
+		`CREATE TABLE IF NOT EXISTS kv_keyspaces (prefix BYTEA PRIMARY KEY, kind TEXT NOT NULL)`,
+		`ALTER TABLE kv ADD COLUMN IF NOT EXISTS doc JSONB`,
+	},
+
+	// Version 6: Commit decisions of the sharded transactions. See sharded.go.
+	{
+		`CREATE TABLE IF NOT EXISTS kv_2pc (gid TEXT PRIMARY KEY, created_at TIMESTAMPTZ NOT NULL DEFAULT now())`,
+	},
+
+	// Version 7: Long keys. Btree indexes cannot hold the large keys, so keys
+	// are made unique by their hash, equality lookups use hash indexes and
+	// ordered scans use a btree index on the key prefix. See keys.go.
+	{
+		`ALTER TABLE kv DROP CONSTRAINT IF EXISTS kv_pkey`,
+		`ALTER TABLE kv ALTER COLUMN key SET NOT NULL`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS kv_key_hash ON kv (sha256(key))`,
+		`CREATE INDEX IF NOT EXISTS kv_key_eq ON kv USING HASH (key)`,
+		`CREATE INDEX IF NOT EXISTS kv_key_prefix ON kv (substring(key FROM 1 FOR 1024))`,
+
+		`ALTER TABLE kv_history DROP CONSTRAINT IF EXISTS kv_history_pkey`,
+		`CREATE INDEX IF NOT EXISTS kv_history_key ON kv_history USING HASH (key)`,
+
+		`ALTER TABLE kv_index DROP CONSTRAINT IF EXISTS kv_index_pkey`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS kv_index_entry ON kv_index (name, ikey, sha256(key))`,
+		`DROP INDEX IF EXISTS kv_index_key`,
+		`CREATE INDEX IF NOT EXISTS kv_index_key ON kv_index USING HASH (key)`,
+	},
+
+	// Version 8: Work queues. See queue.go.
+	{
+		`CREATE TABLE kv_queue (id BIGSERIAL PRIMARY KEY, queue TEXT NOT NULL, payload BYTEA NOT NULL, priority INT NOT NULL, attempts INT NOT NULL DEFAULT 0, dead BOOLEAN NOT NULL DEFAULT FALSE, visible_at TIMESTAMPTZ NOT NULL, created_at TIMESTAMPTZ NOT NULL DEFAULT now())`,
+		`CREATE INDEX kv_queue_ready ON kv_queue (queue, priority DESC, visible_at, id) WHERE NOT dead`,
+	},
+
+	// Version 9: Full replica identity is used only by the change feed, which
+	// sets it up when it is enabled. Earlier version 7 databases had it set
+	// unconditionally. See changes.go.
+	{
+		`DO $$ BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_publication WHERE pubname = 'kvpostgres_changes') THEN
+				ALTER TABLE kv REPLICA IDENTITY DEFAULT;
+			END IF;
+		END $$`,
+	},
+}
+
+// SchemaVersion returns the schema version of the databases created or
+// upgraded by this package.
+func SchemaVersion() int {
+	return len(migrations)
+}
+
+// SchemaVersionError is returned when a database was written by a newer
+// version of this package, which uses an unknown schema version.
+type SchemaVersionError struct {
+	// Version is the schema version of the database.
+	Version int
+
+	// Supported is the latest schema version known to this package.
+	Supported int
+}
+
+func (e *SchemaVersionError) Error() string {
+	return fmt.Sprintf("database schema version %d is newer than the supported version %d", e.Version, e.Supported)
+}
+
+func (e *SchemaVersionError) Unwrap() error {
+	return errors.ErrUnsupported
+}
+
+// migrate upgrades the database schema to the latest version. All pending
+// migrations are applied in a single transaction, so a failed upgrade leaves
+// the schema unchanged.
+func migrate(ctx context.Context, db *sql.DB) (status error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if status != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", schemaLockID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS kv_meta (name TEXT PRIMARY KEY, value TEXT NOT NULL)`); err != nil {
+		return err
+	}
+
+	var version int
+	var value string
+	switch err := tx.QueryRowContext(ctx, "SELECT value FROM kv_meta WHERE name = 'schema_version'").Scan(&value); {
+	case errors.Is(err, sql.ErrNoRows):
+		version = 0
+	case err != nil:
+		return err
+	default:
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid schema version %q: %w", value, err)
+		}
+		version = v
+	}
+
+	if version > len(migrations) {
+		return &SchemaVersionError{Version: version, Supported: len(migrations)}
+	}
+	if version == len(migrations) {
+		return tx.Commit()
+	}
+
+	for i := version; i < len(migrations); i++ {
+		for _, q := range migrations[i] {
+			if _, err := tx.ExecContext(ctx, q); err != nil {
+				return fmt.Errorf("could not migrate schema to version %d: %w", i+1, err)
+			}
+		}
+	}
+	q := "INSERT INTO kv_meta (name, value) VALUES ('schema_version', $1) ON CONFLICT (name) DO UPDATE SET value = EXCLUDED.value"
+	if _, err := tx.ExecContext(ctx, q, strconv.Itoa(len(migrations))); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	slog.Info("database schema is migrated", "from", version, "to", len(migrations))
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+// startRaw starts a postgres server in the data directory and returns a
+// connection pool without creating the key-value schema.
+func startRaw(ctx context.Context, t *testing.T, dataDir string) *sql.DB {
+	stopf, err := Start(ctx, dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(stopf)
+
+	connector, err := pq.NewConnector(fmt.Sprintf("user=postgres dbname=%s host=%s", defaultDB, dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(connector)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigrateLegacyLayout(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	// Layout used before the schema was versioned, with partial upgrades.
+	raw := startRaw(ctx, t, dbDir)
+	for _, q := range []string{
+		`CREATE TABLE kv (key BYTEA PRIMARY KEY, value BYTEA)`,
+		`INSERT INTO kv (key, value) VALUES ('/a', 'a')`,
+		`CREATE SEQUENCE kv_revision`,
+		`ALTER TABLE kv ADD COLUMN revision BIGINT`,
+	} {
+		if _, err := raw.ExecContext(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := Connect(ctx, dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var version string
+	if err := raw.QueryRowContext(ctx, "SELECT value FROM kv_meta WHERE name = 'schema_version'").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != strconv.Itoa(SchemaVersion()) {
+		t.Errorf("got schema version %s, want %d", version, SchemaVersion())
+	}
+	// Full replica identity is only used with the change feed.
+	var identity string
+	if err := raw.QueryRowContext(ctx, "SELECT relreplident FROM pg_class WHERE oid = 'kv'::regclass").Scan(&identity); err != nil {
+		t.Fatal(err)
+	}
+	if identity != "d" {
+		t.Errorf("got replica identity %q, want the default", identity)
+	}
+
+	snap, err := db.NewSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Discard(ctx)
+
+	r, err := snap.Get(ctx, "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(r); string(data) != "a" {
+		t.Errorf("got %q, want %q", data, "a")
+	}
+
+	// Reopening a migrated database is a no-op.
+	db2, err := Connect(ctx, dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db2.Close()
+}
+
+func TestMigrateNewerVersion(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	db, err := New(ctx, dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	newer := strconv.Itoa(SchemaVersion() + 1)
+	if _, err := db.db.ExecContext(ctx, "UPDATE kv_meta SET value = $1 WHERE name = 'schema_version'", newer); err != nil {
+		t.Fatal(err)
+	}
+
+	var verr *SchemaVersionError
+	if _, err := Connect(ctx, dbDir); !errors.As(err, &verr) || !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("wanted SchemaVersionError, got %v", err)
+	}
+	if verr.Version != SchemaVersion()+1 || verr.Supported != SchemaVersion() {
+		t.Errorf("got %+v", verr)
+	}
+}
@@ -0,0 +1,618 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"iter"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// recoverInterval is the interval between the background recovery runs that
+// resolve the prepared transactions left behind by interrupted commits.
+const recoverInterval = 10 * time.Second
+
+// decisionRetention is the minimum age of a commit decision before it can be
+// removed, which allows for the clock skew between the shards.
+const decisionRetention = time.Minute
+
+// ShardedDatabase is a key-value store that partitions the keys by ranges
+// across multiple databases. Transactions that modify multiple shards are
+// committed atomically with the postgres two-phase commit, which requires
+// a non-zero max_prepared_transactions setting on all shards.
+type ShardedDatabase struct {
+	shards []*Database
+
+	// bounds[i] is the smallest key in the shards[i+1].
+	bounds []string
+
+	// cancel stops the background recovery and wg waits for it.
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// ShardedTransaction is a transaction or a snapshot on a sharded database,
+// which has a postgres transaction open on every shard. Snapshots of the
+// shards are taken independently, so a concurrent cross-shard commit may be
+// visible in some shards and not in the others.
+type ShardedTransaction struct {
+	db  *ShardedDatabase
+	txs []*Transaction
+}
+
+// NewSharded creates a sharded database from the given databases and the
+// range boundaries between them; boundary keys must be non-empty and in
+// strictly increasing order and the number of boundaries must be one less
+// than the number of shards. Keys smaller than the first boundary are stored
+// in the first shard, keys from the first boundary up to the second
+// boundary are stored in the second shard, and so on.
+//
+// Sharded database owns the shards, so they are closed when the sharded
+// database is closed. Order of the shards must not be changed across
+// restarts, because it is used to recover the interrupted commits, which is
+// performed when the sharded database is created and periodically in the
+// background.
+func NewSharded(ctx context.Context, shards []*Database, bounds []string) (*ShardedDatabase, error) {
+	if len(shards) == 0 || len(bounds) != len(shards)-1 {
+		return nil, os.ErrInvalid
+	}
+	for i, b := range bounds {
+		if len(b) == 0 || (i > 0 && b <= bounds[i-1]) {
+			return nil, fmt.Errorf("shard boundaries must be non-empty and increasing: %w", os.ErrInvalid)
+		}
+	}
+
+	s := &ShardedDatabase{
+		shards: shards,
+		bounds: bounds,
+	}
+	if err := s.Recover(ctx); err != nil {
+		return nil, err
+	}
+
+	rctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.wg.Add(1)
+	go s.recoverLoop(rctx)
+	return s, nil
+}
+
+// Close stops the background recovery and closes all shards.
+func (s *ShardedDatabase) Close() error {
+	s.cancel()
+	s.wg.Wait()
+
+	var errs []error
+	for _, d := range s.shards {
+		errs = append(errs, d.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// NewTransaction creates a new transaction.
+func (s *ShardedDatabase) NewTransaction(ctx context.Context) (*ShardedTransaction, error) {
+	return s.begin(ctx, (*Database).NewTransaction)
+}
+
+// NewSnapshot creates a read-only snapshot of the key-value database.
+func (s *ShardedDatabase) NewSnapshot(ctx context.Context) (*ShardedTransaction, error) {
+	return s.begin(ctx, (*Database).NewSnapshot)
+}
+
+func (s *ShardedDatabase) begin(ctx context.Context, newf func(*Database, context.Context) (*Transaction, error)) (_ *ShardedTransaction, status error) {
+	t := &ShardedTransaction{db: s}
+	defer func() {
+		if status != nil {
+			t.rollback(ctx)
+		}
+	}()
+
+	for _, d := range s.shards {
+		tx, err := newf(d, ctx)
+		if err != nil {
+			return nil, err
+		}
+		t.txs = append(t.txs, tx)
+	}
+	return t, nil
+}
+
+// shard returns the index of the shard for a key.
+func (s *ShardedDatabase) shard(k string) int {
+	i := 0
+	for i < len(s.bounds) && k >= s.bounds[i] {
+		i++
+	}
+	return i
+}
+
+// shardRange returns the intersection of the given range with the range of
+// a shard. Returns false if the intersection is empty.
+func (s *ShardedDatabase) shardRange(i int, beg, end string) (string, string, bool) {
+	if i > 0 && (beg == "" || beg < s.bounds[i-1]) {
+		beg = s.bounds[i-1]
+	}
+	if i < len(s.bounds) && (end == "" || end > s.bounds[i]) {
+		end = s.bounds[i]
+	}
+	if end != "" && beg >= end {
+		return "", "", false
+	}
+	return beg, end, true
+}
+
+// Get returns the value for a given key.
+func (t *ShardedTransaction) Get(ctx context.Context, k string) (io.Reader, error) {
+	if t.txs == nil {
+		return nil, os.ErrClosed
+	}
+	if len(k) == 0 {
+		return nil, os.ErrInvalid
+	}
+	return t.txs[t.db.shard(k)].Get(ctx, k)
+}
+
+// Set creates or updates a key-value pair.
+func (t *ShardedTransaction) Set(ctx context.Context, k string, v io.Reader) error {
+	if t.txs == nil {
+		return os.ErrClosed
+	}
+	if v == nil || len(k) == 0 {
+		return os.ErrInvalid
+	}
+	return t.txs[t.db.shard(k)].Set(ctx, k, v)
+}
+
+// Delete removes a key-value pair.
+func (t *ShardedTransaction) Delete(ctx context.Context, k string) error {
+	if t.txs == nil {
+		return os.ErrClosed
+	}
+	if len(k) == 0 {
+		return os.ErrInvalid
+	}
+	return t.txs[t.db.shard(k)].Delete(ctx, k)
+}
+
+// Ascend returns key-value pairs in a given range, in ascending order.
+func (t *ShardedTransaction) Ascend(ctx context.Context, beg, end string, errp *error) iter.Seq2[string, io.Reader] {
+	return func(yield func(string, io.Reader) bool) {
+		if t.txs == nil {
+			*errp = os.ErrClosed
+			return
+		}
+		if beg > end && end != "" {
+			*errp = os.ErrInvalid
+			return
+		}
+		// Shards hold disjoint key ranges in the shard order.
+		for i := 0; i < len(t.txs); i++ {
+			if !t.scanShard(ctx, i, beg, end, false, errp, yield) {
+				return
+			}
+		}
+	}
+}
+
+// Descend returns key-value pairs in a given range, in descending order.
+func (t *ShardedTransaction) Descend(ctx context.Context, beg, end string, errp *error) iter.Seq2[string, io.Reader] {
+	return func(yield func(string, io.Reader) bool) {
+		if t.txs == nil {
+			*errp = os.ErrClosed
+			return
+		}
+		if beg > end && end != "" {
+			*errp = os.ErrInvalid
+			return
+		}
+		for i := len(t.txs) - 1; i >= 0; i-- {
+			if !t.scanShard(ctx, i, beg, end, true, errp, yield) {
+				return
+			}
+		}
+	}
+}
+
+// scanShard passes the key-value pairs in the given range from a shard to
+// the yield function. Returns false if the iteration must stop.
+func (t *ShardedTransaction) scanShard(ctx context.Context, i int, beg, end string, desc bool, errp *error, yield func(string, io.Reader) bool) bool {
+	b, e, ok := t.db.shardRange(i, beg, end)
+	if !ok {
+		return true
+	}
+	var err error
+	seq := t.txs[i].Ascend(ctx, b, e, &err)
+	if desc {
+		seq = t.txs[i].Descend(ctx, b, e, &err)
+	}
+	for k, v := range seq {
+		if !yield(k, v) {
+			return false
+		}
+	}
+	if err != nil {
+		*errp = err
+		return false
+	}
+	return true
+}
+
+// Discard releases a snapshot.
+func (t *ShardedTransaction) Discard(ctx context.Context) error {
+	return t.Rollback(ctx)
+}
+
+// Rollback drops a transaction.
+func (t *ShardedTransaction) Rollback(ctx context.Context) error {
+	if t.txs == nil {
+		return os.ErrClosed
+	}
+	return t.rollback(ctx)
+}
+
+func (t *ShardedTransaction) rollback(ctx context.Context) error {
+	var errs []error
+	for _, tx := range t.txs {
+		if tx.tx != nil {
+			errs = append(errs, tx.Rollback(ctx))
+		}
+	}
+	t.txs = nil
+	return errors.Join(errs...)
+}
+
+// Commit commits a transaction. Transactions that modify a single shard are
+// committed directly, otherwise, the two-phase commit is used with the first
+// modified shard as the coordinator: other modified shards are prepared
+// first, and the commit on the coordinator, which records the decision, is
+// the commit point of the transaction.
+func (t *ShardedTransaction) Commit(ctx context.Context) (status error) {
+	if t.txs == nil {
+		return os.ErrClosed
+	}
+	defer func() {
+		if status != nil {
+			t.rollback(ctx)
+		}
+		t.txs = nil
+	}()
+
+	// Read-only participants are committed first, because they only need to
+	// be validated for serializability.
+	var writers []int
+	for i, tx := range t.txs {
+		if len(tx.writes) > 0 {
+			writers = append(writers, i)
+			continue
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return err
+		}
+	}
+	if len(writers) == 0 {
+		return nil
+	}
+	if len(writers) == 1 {
+		return t.txs[writers[0]].Commit(ctx)
+	}
+
+	coordinator := writers[0]
+	gid, err := newGID(coordinator)
+	if err != nil {
+		return err
+	}
+
+	// Coordinator holds the gid lock till its transaction ends, so that the
+	// recovery can tell an interrupted commit from an in-flight commit.
+	if _, err := t.txs[coordinator].tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", gidLockKey(gid)); err != nil {
+		return err
+	}
+
+	// prepared holds the names of the prepared transactions by the shard.
+	prepared := make(map[int]string)
+	abort := func() {
+		for i, name := range prepared {
+			if err := t.db.shards[i].finishPrepared(ctx, name, false); err != nil {
+				slog.Warn("could not rollback prepared transaction", "shard", i, "gid", name, "err", err)
+			}
+		}
+	}
+	for _, i := range writers[1:] {
+		name, err := t.txs[i].prepare(ctx, gid)
+		if err != nil {
+			abort()
+			return err
+		}
+		prepared[i] = name
+	}
+
+	// Commit point of the distributed transaction.
+	ctx, span := t.db.shards[coordinator].startSpan(ctx, "kvpostgres.CommitDecision", Attribute{Key: "kv.gid", Value: gid})
+	q := "INSERT INTO kv_2pc (gid, created_at) VALUES ($1, clock_timestamp())"
+	if _, err := t.txs[coordinator].tx.ExecContext(ctx, q, gid); err != nil {
+		endSpan(span, err)
+		abort()
+		return err
+	}
+	if err := t.txs[coordinator].Commit(ctx); err != nil {
+		endSpan(span, err)
+		// Coordinator may have committed even when the commit fails, e.g.
+		// when the connection is lost, so participants are resolved only by
+		// the recorded decision. They are left to the recovery when the
+		// decision is not known yet.
+		commit, known, derr := t.db.decision(ctx, coordinator, gid)
+		if derr != nil || !known {
+			slog.Warn("outcome of the commit is unknown; prepared transactions are left to the recovery", "gid", gid, "err", err, "check", derr)
+			return fmt.Errorf("could not determine the outcome of transaction %s: %w", gid, err)
+		}
+		if !commit {
+			abort()
+			return err
+		}
+	} else {
+		endSpan(span, nil)
+	}
+
+	// Transaction is committed, so failures from here on are left to the
+	// recovery instead of being reported to the caller.
+	done := true
+	for i, name := range prepared {
+		if err := t.db.shards[i].finishPrepared(ctx, name, true); err != nil {
+			slog.Warn("could not commit prepared transaction; it is left to the recovery", "shard", i, "gid", name, "err", err)
+			done = false
+		}
+	}
+	if done {
+		if _, err := t.db.shards[coordinator].db.ExecContext(ctx, "DELETE FROM kv_2pc WHERE gid = $1", gid); err != nil {
+			slog.Warn("could not remove the commit decision", "gid", gid, "err", err)
+		}
+	}
+	return nil
+}
+
+// Recover resolves the prepared transactions left behind by interrupted
+// commits and removes the commit decisions that are no longer needed.
+// Prepared transactions are committed if their commit decision is recorded in
+// the coordinator shard and rolled back otherwise; transactions whose
+// coordinator transaction is still active are skipped. Recover runs when the
+// sharded database is created and periodically in the background.
+func (s *ShardedDatabase) Recover(ctx context.Context) error {
+	for i, d := range s.shards {
+		names, err := d.listPrepared(ctx)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			gid, coordinator, ok := parseGID(name)
+			if !ok || coordinator >= len(s.shards) {
+				slog.Warn("ignoring prepared transaction with unknown coordinator", "shard", i, "gid", name)
+				continue
+			}
+			commit, known, err := s.decision(ctx, coordinator, gid)
+			if err != nil {
+				return err
+			}
+			if !known {
+				continue
+			}
+			slog.Info("resolving prepared transaction", "shard", i, "gid", name, "commit", commit)
+			if err := d.finishPrepared(ctx, name, commit); err != nil && !isUndefinedObject(err) {
+				return err
+			}
+		}
+	}
+
+	// Decisions are needed only till all participants are resolved. They are
+	// recorded after the participants are prepared, so a decision older than
+	// the listing is not needed when none of the shards has its gid prepared.
+	cutoff := time.Now().Add(-decisionRetention)
+	var pending []string
+	for _, d := range s.shards {
+		names, err := d.listPrepared(ctx)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if gid, _, ok := parseGID(name); ok {
+				pending = append(pending, gid)
+			}
+		}
+	}
+	for _, d := range s.shards {
+		q := "DELETE FROM kv_2pc WHERE created_at < $1 AND gid <> ALL($2)"
+		if _, err := d.db.ExecContext(ctx, q, cutoff, pq.StringArray(pending)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recoverLoop runs the recovery periodically till the context is canceled.
+func (s *ShardedDatabase) recoverLoop(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(recoverInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := s.Recover(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("could not recover prepared transactions", "err", err)
+		}
+	}
+}
+
+// decision returns true if the commit decision of a global transaction is
+// recorded in its coordinator shard. Decision is known only after the
+// coordinator transaction has ended, which releases its gid lock.
+func (s *ShardedDatabase) decision(ctx context.Context, coordinator int, gid string) (commit, known bool, status error) {
+	tx, err := s.shards[coordinator].db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, false, err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock($1)", gidLockKey(gid)).Scan(&locked); err != nil {
+		return false, false, err
+	}
+	if !locked {
+		return false, false, nil
+	}
+	q := "SELECT EXISTS (SELECT 1 FROM kv_2pc WHERE gid = $1)"
+	if err := tx.QueryRowContext(ctx, q, gid).Scan(&commit); err != nil {
+		return false, false, err
+	}
+	return commit, true, nil
+}
+
+// prepare prepares the transaction for the two-phase commit with the global
+// transaction id and releases the transaction. Returns the name of the
+// prepared transaction, which is the global transaction id with the revision
+// of the transaction. Postgres session is not in a transaction after the
+// prepare, so the connection is discarded by the driver.
+//
+// Transaction locks are held by the prepared transaction till it is
+// resolved, so the revision lock is held by a separate session only till the
+// transaction is prepared, and the revisions of the prepared transactions are
+// excluded by CurrentRevision through their names.
+func (t *Transaction) prepare(ctx context.Context, gid string) (_ string, status error) {
+	ctx, span := t.db.startSpan(ctx, "kvpostgres.Prepare", Attribute{Key: "kv.gid", Value: gid})
+	defer func() { endSpan(span, status) }()
+
+	if t.tx == nil {
+		return "", os.ErrClosed
+	}
+	defer func() {
+		if status != nil {
+			t.tx.Rollback()
+		}
+		t.tx = nil
+		t.db.release()
+	}()
+
+	conn, err := t.db.db.Conn(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", int64(revisionLockID)); err != nil {
+		return "", err
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", int64(revisionLockID)); err != nil {
+			// Connection must not be reused with the lock held.
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+	}()
+
+	rev, err := t.assignRevision(ctx)
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s:%d", gid, rev)
+	// NOTE: Transaction id cannot be passed as a value parameter using $1 syntax.
+	if _, err := t.tx.ExecContext(ctx, "PREPARE TRANSACTION "+pq.QuoteLiteral(name)); err != nil {
+		return "", err
+	}
+	t.revision = rev
+	// Releases the sql.Tx and its connection; the error is expected.
+	_ = t.tx.Rollback()
+	return name, nil
+}
+
+// finishPrepared commits or rolls back a prepared transaction.
+func (d *Database) finishPrepared(ctx context.Context, gid string, commit bool) error {
+	q := "ROLLBACK PREPARED "
+	if commit {
+		q = "COMMIT PREPARED "
+	}
+	if _, err := d.db.ExecContext(ctx, q+pq.QuoteLiteral(gid)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// listPrepared returns the names of the prepared transactions created by the
+// sharded databases in this database.
+func (d *Database) listPrepared(ctx context.Context) ([]string, error) {
+	q := "SELECT gid FROM pg_prepared_xacts WHERE database = current_database() AND gid LIKE 'kvpostgres:%'"
+	rows, err := d.db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var gids []string
+	for rows.Next() {
+		var gid string
+		if err := rows.Scan(&gid); err != nil {
+			return nil, err
+		}
+		gids = append(gids, gid)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return gids, nil
+}
+
+// newGID returns a new global transaction id for the two-phase commit, which
+// includes the coordinator shard index.
+func newGID(coordinator int) (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("kvpostgres:%d:%s", coordinator, hex.EncodeToString(id[:])), nil
+}
+
+// parseGID returns the global transaction id and the coordinator shard index
+// from the name of a prepared transaction, which may have the revision of the
+// transaction after the global transaction id.
+func parseGID(name string) (string, int, bool) {
+	parts := strings.Split(name, ":")
+	if (len(parts) != 3 && len(parts) != 4) || parts[0] != "kvpostgres" {
+		return "", 0, false
+	}
+	coordinator, err := strconv.Atoi(parts[1])
+	if err != nil || coordinator < 0 {
+		return "", 0, false
+	}
+	return strings.Join(parts[:3], ":"), coordinator, true
+}
+
+// gidLockKey returns the advisory lock key that is held by the coordinator
+// transaction of a global transaction.
+func gidLockKey(gid string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(gid))
+	return int64(h.Sum64())
+}
+
+// isUndefinedObject returns true if the error reports a missing object, e.g.
+// a prepared transaction that is already resolved.
+func isUndefinedObject(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "42704"
+}
@@ -0,0 +1,320 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/visvasity/kv"
+	"github.com/visvasity/kvtests"
+)
+
+func newTestSharded(ctx context.Context, t *testing.T, bounds ...string) *ShardedDatabase {
+	var shards []*Database
+	for i := 0; i <= len(bounds); i++ {
+		dbDir := filepath.Join(t.TempDir(), "database")
+		t.Log("using database dir", dbDir)
+
+		pg, err := New(ctx, dbDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shards = append(shards, pg)
+	}
+	s, err := NewSharded(ctx, shards, bounds)
+	if err != nil {
+		for _, pg := range shards {
+			pg.Close()
+		}
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestShardRange(t *testing.T) {
+	s := &ShardedDatabase{bounds: []string{"/b", "/d"}}
+
+	for _, c := range []struct {
+		key   string
+		shard int
+	}{{"/a", 0}, {"/b", 1}, {"/c", 1}, {"/d", 2}, {"/z", 2}} {
+		if got := s.shard(c.key); got != c.shard {
+			t.Errorf("shard(%q): got %d, want %d", c.key, got, c.shard)
+		}
+	}
+
+	for _, c := range []struct {
+		shard    int
+		beg, end string
+		ok       bool
+		b, e     string
+	}{
+		{0, "", "", true, "", "/b"},
+		{1, "", "", true, "/b", "/d"},
+		{2, "", "", true, "/d", ""},
+		{0, "/c", "", false, "", ""},
+		{1, "/c", "/z", true, "/c", "/d"},
+		{2, "/a", "/c", false, "", ""},
+	} {
+		b, e, ok := s.shardRange(c.shard, c.beg, c.end)
+		if ok != c.ok || b != c.b || e != c.e {
+			t.Errorf("shardRange(%d, %q, %q): got %q %q %v, want %q %q %v", c.shard, c.beg, c.end, b, e, ok, c.b, c.e, c.ok)
+		}
+	}
+
+	gid := mustGID(t, 2)
+	if g, c, ok := parseGID(gid); !ok || c != 2 || g != gid {
+		t.Errorf("parseGID: got %q %d %v, want %q 2 true", g, c, ok, gid)
+	}
+	if g, c, ok := parseGID(gid + ":42"); !ok || c != 2 || g != gid {
+		t.Errorf("parseGID with revision: got %q %d %v, want %q 2 true", g, c, ok, gid)
+	}
+}
+
+func mustGID(t *testing.T, coordinator int) string {
+	gid, err := newGID(coordinator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gid
+}
+
+func TestSharded(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestSharded(ctx, t, "/m")
+	defer s.Close()
+
+	db := kv.DatabaseFrom(s)
+	if db == nil {
+		t.Fatal("failed to open database")
+	}
+
+	kvtests.TestEmptyKeyInvalid(ctx, t, db)
+	kvtests.TestNonExistentKey(ctx, t, db)
+	kvtests.TestNilValueInvalid(ctx, t, db)
+	kvtests.TestCommitAfterRollbackIgnored(ctx, t, db)
+	kvtests.TestRollbackAfterCommitIgnored(ctx, t, db)
+	kvtests.TestSnapshotRepeatableRead(ctx, t, db)
+	kvtests.TestSnapshotFrozenAtCreation(ctx, t, db)
+	kvtests.TestDisjointTransactionCommit(ctx, t, db)
+	kvtests.TestConflictingTransactionCommit(ctx, t, db)
+	kvtests.TestRangeBeginEndInvalid(ctx, t, db)
+	kvtests.TestRangeFullDatabaseScan(ctx, t, db)
+	kvtests.TestRangeBoundsInclusion(ctx, t, db)
+	kvtests.TestRangeDescendBounds(ctx, t, db)
+	kvtests.TestSnapshotIteratorStability(ctx, t, db)
+	kvtests.TestSnapshotIteratorPrefixRange(ctx, t, db)
+	kvtests.TestDiscardedSnapshotBehavior(ctx, t, db)
+	kvtests.TestTransactionVisibility(ctx, t, db)
+	kvtests.TestTransactionDeleteVisibility(ctx, t, db)
+	kvtests.TestTransactionDeleteRecreate(ctx, t, db)
+	kvtests.TestTransactionRollbackVisibility(ctx, t, db)
+	kvtests.TestLargeValueRoundtrip(ctx, t, db)
+	kvtests.TestZeroLengthValue(ctx, t, db)
+	kvtests.TestPrefixCleanupTrailingFF(ctx, t, db)
+}
+
+func TestShardedTwoPhaseCommit(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestSharded(ctx, t, "/m")
+	defer s.Close()
+
+	tx, err := s.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Set(ctx, "/a", strings.NewReader("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Set(ctx, "/z", strings.NewReader("z")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each key must be stored in its own shard.
+	for i, k := range []string{"/a", "/z"} {
+		snap, err := s.shards[i].NewSnapshot(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := snap.Get(ctx, k); err != nil {
+			t.Errorf("shard %d: key %q: %v", i, k, err)
+		}
+		snap.Discard(ctx)
+	}
+
+	snap, err := s.NewSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Discard(ctx)
+
+	var keys []string
+	for k, v := range snap.Descend(ctx, "", "", &err) {
+		data, _ := io.ReadAll(v)
+		if string(data) != k[1:] {
+			t.Errorf("key %q: got value %q", k, data)
+		}
+		keys = append(keys, k)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(keys, ",") != "/z,/a" {
+		t.Errorf("got keys %v, want [/z /a]", keys)
+	}
+
+	// No prepared transactions or commit decisions must be left behind.
+	for i, d := range s.shards {
+		gids, err := d.listPrepared(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n int
+		if err := d.db.QueryRowContext(ctx, "SELECT count(*) FROM kv_2pc").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if len(gids) != 0 || n != 0 {
+			t.Errorf("shard %d: got %d prepared transactions and %d decisions", i, len(gids), n)
+		}
+	}
+}
+
+func TestShardedRecover(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSharded(ctx, t, "/m")
+	defer s.Close()
+
+	// prepareOrphan leaves a prepared transaction that sets the key in the
+	// second shard, as if the commit was interrupted.
+	prepareOrphan := func(k string) string {
+		gid, err := newGID(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx, err := s.shards[1].NewTransaction(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Set(ctx, k, strings.NewReader(k)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tx.prepare(ctx, gid); err != nil {
+			t.Fatal(err)
+		}
+		return gid
+	}
+	exists := func(k string) bool {
+		snap, err := s.shards[1].NewSnapshot(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer snap.Discard(ctx)
+		_, err = snap.Get(ctx, k)
+		return err == nil
+	}
+	numPrepared := func() int {
+		gids, err := s.shards[1].listPrepared(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(gids)
+	}
+
+	// Transaction without a decision is rolled back.
+	prepareOrphan("/x")
+
+	// Prepared transactions do not block the other commits on the shard, and
+	// the current revision stays below their revisions till they are resolved.
+	tx, err := s.shards[1].NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Set(ctx, "/w", strings.NewReader("/w")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatalf("wanted commits to succeed with a prepared transaction: %v", err)
+	}
+	if current, err := s.shards[1].CurrentRevision(ctx); err != nil || current >= tx.CommitRevision() {
+		t.Errorf("wanted current revision below the prepared transaction, got %d (%v)", current, err)
+	}
+
+	if err := s.Recover(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if exists("/x") {
+		t.Errorf("key /x must be rolled back")
+	}
+
+	// Transaction with a decision is committed.
+	gid := prepareOrphan("/y")
+	if _, err := s.shards[0].db.ExecContext(ctx, "INSERT INTO kv_2pc (gid) VALUES ($1)", gid); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Recover(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !exists("/y") {
+		t.Errorf("key /y must be committed")
+	}
+
+	// Transaction with an active coordinator is left alone till the
+	// coordinator ends.
+	coord, err := s.shards[0].NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gid = prepareOrphan("/z")
+	if _, err := coord.tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", gidLockKey(gid)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Recover(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := numPrepared(); n != 1 {
+		t.Errorf("got %d prepared transactions, want 1", n)
+	}
+	if err := coord.Rollback(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Recover(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if exists("/z") {
+		t.Errorf("key /z must be rolled back")
+	}
+	if n := numPrepared(); n != 0 {
+		t.Errorf("got %d prepared transactions, want 0", n)
+	}
+
+	// Resolved decisions are removed only after the retention period.
+	var n int
+	if err := s.shards[0].db.QueryRowContext(ctx, "SELECT count(*) FROM kv_2pc").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("got %d decisions before the retention period, want 1", n)
+	}
+	q := "UPDATE kv_2pc SET created_at = created_at - make_interval(secs => $1)"
+	if _, err := s.shards[0].db.ExecContext(ctx, q, decisionRetention.Seconds()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Recover(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.shards[0].db.QueryRowContext(ctx, "SELECT count(*) FROM kv_2pc").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("got %d decisions, want 0", n)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+
+	"github.com/lib/pq"
+)
+
+// ExportSnapshot exports the current snapshot of the transaction and returns
+// its identifier, which can be passed to Database.NewSnapshotAt to create
+// more snapshots with exactly the same view of the database on other
+// connections. Exported identifier is valid only till the transaction is
+// committed or rolled back.
+//
+// Snapshots cannot be exported from transactions with uncommitted changes
+// because the importers would not observe them, or from the snapshots served
+// by the replicas; os.ErrInvalid is returned in such cases.
+func (t *Transaction) ExportSnapshot(ctx context.Context) (_ string, status error) {
+	ctx, span := t.db.startSpan(ctx, "kvpostgres.ExportSnapshot")
+	defer func() { endSpan(span, status) }()
+
+	if t.tx == nil {
+		return "", os.ErrClosed
+	}
+	if len(t.writes) > 0 {
+		return "", fmt.Errorf("transaction has uncommitted changes: %w", os.ErrInvalid)
+	}
+	if t.replica != nil {
+		return "", fmt.Errorf("replica snapshots cannot be exported: %w", os.ErrInvalid)
+	}
+	return t.exportSnapshot(ctx)
+}
+
+// exportSnapshot exports the current snapshot of the transaction. Snapshots
+// exported from a replica can only be imported on the same replica.
+func (t *Transaction) exportSnapshot(ctx context.Context) (string, error) {
+	var id string
+	if err := t.tx.QueryRowContext(ctx, "SELECT pg_export_snapshot()").Scan(&id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// NewSnapshotAt creates a read-only snapshot of the key-value database with
+// the same view as the transaction that exported the snapshot id. See
+// Transaction.ExportSnapshot.
+func (d *Database) NewSnapshotAt(ctx context.Context, id string) (_ *Transaction, status error) {
+	ctx, span := d.startSpan(ctx, "kvpostgres.NewSnapshotAt")
+	defer func() { endSpan(span, status) }()
+
+	if len(id) == 0 {
+		return nil, os.ErrInvalid
+	}
+	return d.importSnapshot(ctx, nil, id)
+}
+
+// importSnapshot creates a read-only snapshot with the exported snapshot id on
+// the primary or on the replica that exported the snapshot.
+func (d *Database) importSnapshot(ctx context.Context, r *replica, id string) (*Transaction, error) {
+	// NOTE: Snapshot id cannot be passed as a value parameter using $1 syntax.
+	setq := "SET TRANSACTION SNAPSHOT " + pq.QuoteLiteral(id)
+	if r == nil {
+		return d.begin(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable, ReadOnly: true}, setq)
+	}
+	t, err := d.beginOn(ctx, r.db, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}, setq)
+	if err != nil {
+		return nil, err
+	}
+	t.replica = r
+	return t, nil
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportSnapshot(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	db, err := New(ctx, dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	setKey := func(k, v string) {
+		tx, err := db.NewTransaction(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Set(ctx, k, strings.NewReader(v)); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setKey("/a", "one")
+
+	snap, err := db.NewSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Discard(ctx)
+
+	id, err := snap.ExportSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setKey("/a", "two")
+
+	imported, err := db.NewSnapshotAt(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer imported.Discard(ctx)
+
+	r, err := imported.Get(ctx, "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(data); s != "one" {
+		t.Fatalf("wanted value from the exported snapshot, got %q", s)
+	}
+
+	tx, err := db.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := tx.Set(ctx, "/b", strings.NewReader("b")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.ExportSnapshot(ctx); !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("wanted os.ErrInvalid for transaction with changes, got %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/lib/pq"
+)
+
+// Standby is a hot standby postgres server that continuously streams the
+// changes from a primary server. Standby accepts read-only queries and can
+// be promoted to a primary when the primary is lost.
+type Standby struct {
+	pgctl   *pgCtl
+	dataDir string
+	stopf   func()
+
+	mu       sync.Mutex
+	promoted bool
+}
+
+// StartStandby creates a hot standby for the private postgres server in the
+// primary data directory and starts it in the standby data directory. Primary
+// server must be running. When the standby data directory doesn't exist, it
+// is bootstrapped with a base backup of the primary, otherwise, the existing
+// standby is started and it resumes streaming from where it left off.
+//
+// Primary keeps the changes in a replication slot until they are received by
+// the standby, so the slot must be dropped on the primary if the standby is
+// removed permanently. See StandbySlotName.
+func StartStandby(ctx context.Context, primaryDataDir, standbyDataDir string) (_ *Standby, status error) {
+	primaryDir, err := filepath.Abs(primaryDataDir)
+	if err != nil {
+		return nil, err
+	}
+	standbyDir, err := filepath.Abs(standbyDataDir)
+	if err != nil {
+		return nil, err
+	}
+	if primaryDir == standbyDir {
+		return nil, fmt.Errorf("standby must use a different data directory: %w", os.ErrInvalid)
+	}
+
+	pgctl, err := findPgctl()
+	if err != nil {
+		return nil, err
+	}
+	v := &pgCtl{binPath: pgctl}
+
+	if _, err := os.Stat(standbyDir); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if err := v.basebackup(ctx, primaryDir, standbyDir); err != nil {
+			return nil, err
+		}
+	}
+
+	stopf, err := Start(ctx, standbyDir)
+	if err != nil {
+		return nil, err
+	}
+	s := &Standby{
+		pgctl:   v,
+		dataDir: standbyDir,
+		stopf:   stopf,
+	}
+	return s, nil
+}
+
+// DataDir returns the data directory of the standby.
+func (s *Standby) DataDir() string {
+	return s.dataDir
+}
+
+// Promote stops the streaming from the primary and turns the standby into a
+// primary server that accepts writes, which can be opened with Connect or
+// New. Promote is idempotent.
+func (s *Standby) Promote(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.promoted {
+		return nil
+	}
+	if err := s.pgctl.promote(ctx, s.dataDir); err != nil {
+		return err
+	}
+	s.promoted = true
+	return nil
+}
+
+// Stop stops the standby server.
+func (s *Standby) Stop() {
+	s.stopf()
+}
+
+// StandbySlotName returns the name of the replication slot on the primary
+// that is used by a standby in the given data directory.
+func StandbySlotName(standbyDataDir string) (string, error) {
+	dir, err := filepath.Abs(standbyDataDir)
+	if err != nil {
+		return "", err
+	}
+	h := fnv.New64a()
+	h.Write([]byte(dir))
+	return fmt.Sprintf("kvpostgres_standby_%016x", h.Sum64()), nil
+}
+
+// basebackup copies the primary data directory into the standby data
+// directory and configures it as a streaming standby of the primary.
+func (v *pgCtl) basebackup(ctx context.Context, primaryDir, standbyDir string) (status error) {
+	// pg_basebackup is part of the same postgres installation as pg_ctl.
+	binPath := filepath.Join(filepath.Dir(v.binPath), "pg_basebackup")
+	if _, err := os.Stat(binPath); err != nil {
+		p, err := exec.LookPath("pg_basebackup")
+		if err != nil {
+			return err
+		}
+		binPath = p
+	}
+
+	slot, err := StandbySlotName(standbyDir)
+	if err != nil {
+		return err
+	}
+	if err := dropStaleSlot(ctx, primaryDir, slot); err != nil {
+		return err
+	}
+
+	tmpDir, err := os.MkdirTemp(filepath.Dir(standbyDir), ".pgdir")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if status != nil {
+			os.RemoveAll(tmpDir)
+		}
+	}()
+
+	cmd := exec.CommandContext(ctx, binPath,
+		"-D", tmpDir, // Standby data directory.
+		"-h", primaryDir, // Primary's unix domain socket directory
+		"-U", "postgres", // Key-Value database uses postgres as the username
+		"-X", "stream", // Stream the WAL while the backup is taken
+		"-R",             // Write the standby.signal file and the primary_conninfo setting
+		"-C", "-S", slot, // Create a replication slot to retain the WAL for the standby
+		"-c", "fast", // Do not wait for a scheduled checkpoint
+	)
+	slog.Info("creating a base backup of the postgres database", "cmd", cmd.Args)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		slog.Warn("could not create base backup", "output", string(bytes.TrimSpace(out)), "err", err)
+		return err
+	}
+
+	// Lock file of the primary is copied as part of the backup.
+	if err := os.Remove(filepath.Join(tmpDir, lockFileName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	// Settings in the postgresql.auto.conf file override the postgresql.conf
+	// settings copied from the primary.
+	conf := fmt.Sprintf("unix_socket_directories = '%s'\n", strings.ReplaceAll(standbyDir, "'", "''"))
+	f, err := os.OpenFile(filepath.Join(tmpDir, "postgresql.auto.conf"), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(conf); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpDir, standbyDir); err != nil {
+		return err
+	}
+	slog.Info("standby directory is initialized successfully", "dir", standbyDir, "primary", primaryDir)
+	return nil
+}
+
+// dropStaleSlot removes the replication slot left behind by an earlier standby
+// in the same data directory, which must be recreated with the new backup.
+func dropStaleSlot(ctx context.Context, primaryDir, slot string) error {
+	connector, err := pq.NewConnector(fmt.Sprintf("user=postgres dbname=%s host=%s", defaultDB, primaryDir))
+	if err != nil {
+		return err
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	q := "SELECT pg_drop_replication_slot(slot_name) FROM pg_replication_slots WHERE slot_name = $1 AND NOT active"
+	if _, err := db.ExecContext(ctx, q, slot); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestStandby(t *testing.T) {
+	ctx := context.Background()
+
+	tmpDir := t.TempDir()
+	primaryDir := filepath.Join(tmpDir, "primary")
+	standbyDir := filepath.Join(tmpDir, "standby")
+	t.Log("using database dirs", primaryDir, standbyDir)
+
+	primary, err := New(ctx, primaryDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+
+	standby, err := StartStandby(ctx, primaryDir, standbyDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer standby.Stop()
+
+	tx, err := primary.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Set(ctx, "/a", strings.NewReader("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Standby is read-only, so it is queried without creating the schema.
+	connector, err := pq.NewConnector(fmt.Sprintf("user=postgres dbname=%s host=%s", defaultDB, standby.DataDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var value []byte
+		err := db.QueryRowContext(ctx, "SELECT value FROM kv WHERE key = $1", "/a").Scan(&value)
+		if err == nil && string(value) == "a" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("change is not streamed to the standby: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if _, err := db.ExecContext(ctx, "DELETE FROM kv"); err == nil {
+		t.Fatalf("wanted non-nil error for writes on the standby")
+	}
+
+	// Fail over to the standby.
+	primary.Close()
+	if err := standby.Promote(ctx); err != nil {
+		t.Fatal(err)
+	}
+	promoted, err := Connect(ctx, standby.DataDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer promoted.Close()
+
+	tx, err = promoted.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	r, err := tx.Get(ctx, "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(r); string(data) != "a" {
+		t.Errorf("got %q, want %q", data, "a")
+	}
+	if err := tx.Set(ctx, "/b", strings.NewReader("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
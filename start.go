@@ -12,6 +12,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/lib/pq"
 )
@@ -43,6 +45,8 @@ func (v *pgCtl) init(ctx context.Context, dataDir string) (status error) {
 		"-o", "-c listen_addresses=''", // Disable listening for TCP connections
 		"-o", "-c unix_socket_directories="+dataDir, // Unix domain socket is place in the same pg data directory
 		"-o", "-c log_min_messages=INFO", // INFO level.
+		"-o", "-c wal_level=logical", // Enable logical decoding for the change feed
+		"-o", "-c max_prepared_transactions=64", // Enable two-phase commit for the sharded databases
 		"-o", "-c logging_collector=on", // Save logs to files in a directory
 	)
 	slog.Info("initializing the postgres database", "cmd", cmd.Args)
@@ -124,6 +128,16 @@ func (v *pgCtl) start(ctx context.Context, dataDir string) (status error) {
 	return nil
 }
 
+func (v *pgCtl) promote(ctx context.Context, dataDir string) error {
+	cmd := exec.CommandContext(ctx, v.binPath, "promote", "-D", dataDir, "--wait")
+	slog.Info("promoting the postgres standby", "cmd", cmd.Args)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		slog.Warn("could not promote postgres standby", "output", string(bytes.TrimSpace(out)), "err", err)
+		return err
+	}
+	return nil
+}
+
 func (v *pgCtl) stop(dataDir string) error {
 	cmd := exec.Command(v.binPath, "stop", "-D", dataDir, "--wait")
 	slog.Info("stopping the postgres database", "cmd", cmd.Args)
@@ -133,8 +147,46 @@ func (v *pgCtl) stop(dataDir string) error {
 	return nil
 }
 
+// lockFileName is the name of the lock file in the data directory. Every
+// process using the server holds a shared lock on this file, so that the last
+// process can detect that it is the only user of the server. File content is
+// non-empty when the server was started by this package.
+const lockFileName = "kvpostgres.lock"
+
+// controlLockFileName is the name of the lock file in the data directory that
+// serializes starting and stopping the server across processes. It is held
+// exclusively for short durations only.
+const controlLockFileName = "kvpostgres.control.lock"
+
+// servers holds the postgres servers used by this process, keyed by their
+// data directory. Entries are never removed, so that all users of a data
+// directory in this process share the same entry.
+var servers = struct {
+	mu sync.Mutex
+	m  map[string]*server
+}{m: make(map[string]*server)}
+
+type server struct {
+	dataDir string
+
+	// mu serializes starting and stopping of the server in this process. It
+	// is specific to the data directory, so servers in other directories are
+	// not blocked.
+	mu    sync.Mutex
+	refs  int
+	pgctl *pgCtl
+
+	lockFile *os.File
+}
+
 // Start initializes a postgres database and starts a private postgres server
 // in the given directory if it doesn't already exist.
+//
+// Servers are reference-counted per data directory in a process, so the
+// returned stop function only stops the server when it is the last user of
+// the server in this process. Also, the server is not stopped when other
+// processes are using the same data directory or if it was not started by
+// this package.
 func Start(ctx context.Context, dataDir string) (func(), error) {
 	if !filepath.IsAbs(dataDir) {
 		absDir, err := filepath.Abs(dataDir)
@@ -144,46 +196,224 @@ func Start(ctx context.Context, dataDir string) (func(), error) {
 		dataDir = absDir
 	}
 
+	servers.mu.Lock()
+	s, ok := servers.m[dataDir]
+	if !ok {
+		s = &server{dataDir: dataDir}
+		servers.m[dataDir] = s
+	}
+	servers.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.refs > 0 {
+		s.refs++
+		return s.stopFunc(), nil
+	}
+	if err := s.start(ctx); err != nil {
+		return nil, err
+	}
+	s.refs = 1
+	return s.stopFunc(), nil
+}
+
+// findPgctl returns the absolute path to the `pg_ctl` binary.
+func findPgctl() (string, error) {
 	pgctl := PgctlBinaryPath
 	if len(pgctl) == 0 {
 		binPath, err := exec.LookPath("pg_ctl")
 		if err != nil {
-			return nil, err
+			return "", err
 		}
 		pgctl = binPath
 	}
 	if !filepath.IsAbs(pgctl) {
 		binPath, err := filepath.Abs(pgctl)
 		if err != nil {
-			return nil, err
+			return "", err
 		}
 		pgctl = binPath
 	}
 	if _, err := os.Stat(pgctl); err != nil {
-		return nil, err
+		return "", err
+	}
+	return pgctl, nil
+}
+
+// start starts the server if it is not running and takes the shared lock on
+// the data directory. Caller must hold the s.mu.
+func (s *server) start(ctx context.Context) (status error) {
+	pgctl, err := findPgctl()
+	if err != nil {
+		return err
 	}
 
 	v := &pgCtl{
 		binPath: pgctl,
 	}
 
-	if _, err := os.Stat(dataDir); err != nil {
+	if _, err := os.Stat(s.dataDir); err != nil {
 		if !errors.Is(err, os.ErrNotExist) {
-			return nil, err
+			return err
 		}
-		if err := v.init(ctx, dataDir); err != nil {
-			return nil, err
+		if err := v.init(ctx, s.dataDir); err != nil {
+			// Another process may have initialized the same directory.
+			if _, serr := os.Stat(s.dataDir); serr != nil {
+				return err
+			}
 		}
 	}
 
-	if err := v.running(ctx, dataDir); err == nil {
-		return func() {}, nil
-	} else if !errors.Is(err, os.ErrNotExist) {
-		return nil, err
+	// Concurrent processes do not race to start or stop the same server
+	// because both are done with the control lock.
+	control, err := lockControl(ctx, s.dataDir)
+	if err != nil {
+		return err
+	}
+	defer control.Close()
+
+	lockFile, err := os.OpenFile(filepath.Join(s.dataDir, lockFileName), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if status != nil {
+			lockFile.Close()
+		}
+	}()
+
+	if err := v.running(ctx, s.dataDir); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := v.start(ctx, s.dataDir); err != nil {
+			return err
+		}
+		if err := markStarted(lockFile, true); err != nil {
+			v.stop(s.dataDir)
+			return err
+		}
+	}
+
+	if err := lockShared(lockFile); err != nil {
+		return err
+	}
+
+	s.pgctl = v
+	s.lockFile = lockFile
+	return nil
+}
+
+// stopFunc returns a function that drops a reference to the server. Returned
+// function is idempotent.
+func (s *server) stopFunc() func() {
+	var once sync.Once
+	return func() {
+		once.Do(s.release)
+	}
+}
+
+func (s *server) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.refs--; s.refs > 0 {
+		return
+	}
+
+	lockFile, pgctl := s.lockFile, s.pgctl
+	s.lockFile, s.pgctl = nil, nil
+	defer lockFile.Close()
+
+	control, err := lockControl(context.Background(), s.dataDir)
+	if err != nil {
+		slog.Warn("could not lock the data directory", "dir", s.dataDir, "err", err)
+		return
+	}
+	defer control.Close()
+
+	// Shared lock is dropped before checking for other users instead of
+	// upgrading it, which is not atomic. Other processes releasing the server
+	// at the same time wait for the control lock, so exactly one of them
+	// finds no other users.
+	if err := unlockFile(lockFile); err != nil {
+		slog.Warn("could not unlock the data directory", "dir", s.dataDir, "err", err)
+		return
+	}
+	ok, err := tryLockExclusive(lockFile)
+	if err != nil {
+		slog.Warn("could not lock the data directory", "dir", s.dataDir, "err", err)
+		return
+	}
+	if !ok {
+		slog.Info("postgres database is not stopped because it is used by other processes", "dir", s.dataDir)
+		return
+	}
+
+	started, err := isStarted(lockFile)
+	if err != nil {
+		slog.Warn("could not read the data directory lock file", "dir", s.dataDir, "err", err)
+		return
+	}
+	if !started {
+		return
 	}
+	if err := pgctl.stop(s.dataDir); err != nil {
+		slog.Warn("could not stop postgres database", "dir", s.dataDir, "err", err)
+		return
+	}
+	if err := markStarted(lockFile, false); err != nil {
+		slog.Warn("could not update the data directory lock file", "dir", s.dataDir, "err", err)
+	}
+}
 
-	if err := v.start(ctx, dataDir); err != nil {
+// lockControl opens the control lock file in the data directory and locks it
+// exclusively, waiting till it is available or the context is canceled.
+// Closing the returned file releases the lock.
+func lockControl(ctx context.Context, dataDir string) (_ *os.File, status error) {
+	f, err := os.OpenFile(filepath.Join(dataDir, controlLockFileName), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
 		return nil, err
 	}
-	return func() { v.stop(dataDir) }, nil
+	defer func() {
+		if status != nil {
+			f.Close()
+		}
+	}()
+
+	for {
+		ok, err := tryLockExclusive(f)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return f, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func markStarted(f *os.File, started bool) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if started {
+		if _, err := f.WriteAt([]byte("started\n"), 0); err != nil {
+			return err
+		}
+	}
+	return f.Sync()
+}
+
+func isStarted(f *os.File) (bool, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	return fi.Size() > 0, nil
 }
@@ -3,9 +3,16 @@
 package kvpostgres
 
 import (
+	"bufio"
 	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
 	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestStart(t *testing.T) {
@@ -31,3 +38,151 @@ func TestStart(t *testing.T) {
 	}
 	defer db.Close()
 }
+
+func TestStartRefCount(t *testing.T) {
+	ctx := context.Background()
+
+	dataDir := filepath.Join(t.TempDir(), "database")
+
+	db1, err := New(ctx, dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db2, err := New(ctx, dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db2.Close()
+
+	if err := db1.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Server must be running for the second database.
+	tx, err := db2.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Connect(ctx, dataDir); err == nil {
+		t.Fatalf("wanted server to be stopped after the last database is closed")
+	}
+}
+
+// TestStartHelperProcess is not a real test. It is run as a subprocess by
+// TestStartMultiProcess to use the server from another process: it starts
+// the server, reports readiness on stdout and releases the server when its
+// stdin is closed.
+func TestStartHelperProcess(t *testing.T) {
+	dataDir := os.Getenv("KVPOSTGRES_HELPER_DATA_DIR")
+	if dataDir == "" {
+		t.Skip("helper process for TestStartMultiProcess")
+	}
+	stopf, err := Start(context.Background(), dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("ready")
+	io.Copy(io.Discard, os.Stdin)
+	stopf()
+}
+
+// startHelper runs TestStartHelperProcess in a subprocess and waits till it
+// is using the server. Returned function releases the server in the
+// subprocess and waits for it to exit.
+func startHelper(t *testing.T, dataDir string) func() {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestStartHelperProcess$")
+	cmd.Env = append(os.Environ(), "KVPOSTGRES_HELPER_DATA_DIR="+dataDir)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if scanner.Text() == "ready" {
+			break
+		}
+	}
+	if scanner.Err() != nil {
+		t.Fatal(scanner.Err())
+	}
+
+	var once sync.Once
+	stopf := func() {
+		once.Do(func() {
+			stdin.Close()
+			io.Copy(io.Discard, stdout)
+			if err := cmd.Wait(); err != nil {
+				t.Errorf("helper process failed: %v", err)
+			}
+		})
+	}
+	t.Cleanup(stopf)
+	return stopf
+}
+
+func TestStartMultiProcess(t *testing.T) {
+	ctx := context.Background()
+
+	dataDir := filepath.Join(t.TempDir(), "database")
+
+	// Server started by another process must be reused without waiting for
+	// the other process to exit.
+	stopHelper := startHelper(t, dataDir)
+
+	tctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	stopf, err := Start(tctx, dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := Connect(ctx, dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// Server must keep running while another process is using it.
+	stopHelper()
+	db, err = Connect(ctx, dataDir)
+	if err != nil {
+		t.Fatalf("server is stopped while it is in use: %v", err)
+	}
+	db.Close()
+
+	// Last user of the server stops it.
+	stopf()
+	if _, err := Connect(ctx, dataDir); err == nil {
+		t.Fatalf("wanted server to be stopped after the last process releases it")
+	}
+
+	// Both processes release the server at the same time.
+	stopHelper = startHelper(t, dataDir)
+	stopf, err = Start(ctx, dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); stopHelper() }()
+	go func() { defer wg.Done(); stopf() }()
+	wg.Wait()
+	if _, err := Connect(ctx, dataDir); err == nil {
+		t.Fatalf("wanted server to be stopped after both processes release it")
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// RangeEstimate holds the approximate size of a key range.
+type RangeEstimate struct {
+	// Keys is the estimated number of keys in the range.
+	Keys int64
+
+	// Bytes is the estimated size of the keys and values in the range, as
+	// stored in the database.
+	Bytes int64
+}
+
+// PrefixUsage holds the number of keys and their size for a key prefix.
+type PrefixUsage struct {
+	// Prefix is the key prefix, which ends with the delimiter, or a key
+	// without the delimiter after the parent prefix.
+	Prefix string
+
+	// Keys is the number of keys with the prefix.
+	Keys int64
+
+	// Bytes is the size of the keys and values with the prefix, as stored in
+	// the database.
+	Bytes int64
+}
+
+// Count returns the exact number of keys in the given range. Range semantics
+// and validation are same as Ascend.
+func (t *Transaction) Count(ctx context.Context, beg, end string) (_ int64, status error) {
+	ctx, span := t.db.startSpan(ctx, "kvpostgres.Count", Attribute{Key: AttrBegin, Value: beg}, Attribute{Key: AttrEnd, Value: end})
+	defer func() { endSpan(span, status) }()
+
+	if t.tx == nil {
+		return 0, os.ErrClosed
+	}
+	if beg > end && end != "" {
+		return 0, os.ErrInvalid
+	}
+
+	cond, args := keyRange(beg, end, 1)
+	var count int64
+	if err := t.tx.QueryRowContext(ctx, "SELECT count(*) FROM "+t.source()+" WHERE "+cond, args...).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// EstimateRange returns the approximate number of keys and their size in the
+// given range from the planner statistics, without scanning the range. Range
+// semantics and validation are same as Ascend. Statistics are updated by the
+// postgres autovacuum, so the estimates may lag behind the recent changes.
+func (d *Database) EstimateRange(ctx context.Context, beg, end string) (_ *RangeEstimate, status error) {
+	ctx, span := d.startSpan(ctx, "kvpostgres.EstimateRange", Attribute{Key: AttrBegin, Value: beg}, Attribute{Key: AttrEnd, Value: end})
+	defer func() { endSpan(span, status) }()
+
+	if beg > end && end != "" {
+		return nil, os.ErrInvalid
+	}
+
+	// Bounds are inlined so that the planner uses the histogram statistics
+	// for the actual values.
+	cond := "TRUE"
+	if beg != "" {
+		cond += " AND key >= " + byteaLiteral(beg)
+	}
+	if end != "" {
+		cond += " AND key < " + byteaLiteral(end)
+	}
+
+	var plan []byte
+	if err := d.db.QueryRowContext(ctx, "EXPLAIN (FORMAT JSON) SELECT key, value FROM kv WHERE "+cond).Scan(&plan); err != nil {
+		return nil, err
+	}
+	var explain []struct {
+		Plan struct {
+			Rows  float64 `json:"Plan Rows"`
+			Width float64 `json:"Plan Width"`
+		}
+	}
+	if err := json.Unmarshal(plan, &explain); err != nil {
+		return nil, err
+	}
+	if len(explain) != 1 {
+		return nil, fmt.Errorf("unexpected query plan %s: %w", plan, os.ErrInvalid)
+	}
+
+	// NOTE: Planner never estimates less than one row, even for empty ranges.
+	p := explain[0].Plan
+	estimate := &RangeEstimate{
+		Keys:  int64(p.Rows),
+		Bytes: int64(p.Rows * p.Width),
+	}
+	return estimate, nil
+}
+
+// Usage returns the number of keys and their size for the child prefixes of
+// the given prefix, which are the key prefixes up to the first delimiter
+// after the parent prefix, in the key order. Keys without the delimiter after
+// the parent prefix are reported individually. An empty parent prefix
+// selects all keys.
+func (t *Transaction) Usage(ctx context.Context, prefix, delimiter string) (_ []*PrefixUsage, status error) {
+	ctx, span := t.db.startSpan(ctx, "kvpostgres.Usage", Attribute{Key: AttrKey, Value: prefix})
+	defer func() { endSpan(span, status) }()
+
+	if t.tx == nil {
+		return nil, os.ErrClosed
+	}
+	if len(delimiter) == 0 {
+		return nil, os.ErrInvalid
+	}
+
+	cond, args := keyRange(prefix, prefixEnd(prefix), 3)
+	args = append([]any{len(prefix), []byte(delimiter)}, args...)
+	q := "SELECT CASE WHEN pos > 0 THEN substring(key FROM 1 FOR $1::int + pos + octet_length($2::bytea) - 1) ELSE key END AS prefix," +
+		" count(*), sum(octet_length(key) + coalesce(octet_length(value), 0))" +
+		" FROM (SELECT key, value, position($2::bytea IN substring(key FROM $1::int + 1)) AS pos FROM " + t.source() + " WHERE " + cond + ") AS k" +
+		" GROUP BY 1 ORDER BY 1"
+	rows, err := t.tx.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usage []*PrefixUsage
+	for rows.Next() {
+		var p []byte
+		u := new(PrefixUsage)
+		if err := rows.Scan(&p, &u.Keys, &u.Bytes); err != nil {
+			return nil, err
+		}
+		u.Prefix = string(p)
+		usage = append(usage, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// byteaLiteral returns a SQL literal for the bytes of a string.
+func byteaLiteral(s string) string {
+	return `'\x` + hex.EncodeToString([]byte(s)) + `'::bytea`
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCountAndUsage(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	db, err := New(ctx, dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	for i := 0; i < 100; i++ {
+		if err := tx.Set(ctx, fmt.Sprintf("/users/%03d/name", i), strings.NewReader("name")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 50; i++ {
+		if err := tx.Set(ctx, fmt.Sprintf("/groups/%03d", i), strings.NewReader("group")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Set(ctx, "/version", strings.NewReader("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.db.ExecContext(ctx, "ANALYZE kv"); err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := db.NewSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Discard(ctx)
+
+	if _, err := snap.Count(ctx, "/b", "/a"); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("wanted os.ErrInvalid for an invalid range, got %v", err)
+	}
+	for _, c := range []struct {
+		beg, end string
+		want     int64
+	}{
+		{"", "", 151},
+		{"/users/", "/users0", 100},
+		{"/users/010", "/users/020", 10},
+		{"/x", "", 0},
+	} {
+		if n, err := snap.Count(ctx, c.beg, c.end); err != nil || n != c.want {
+			t.Errorf("count [%q, %q): got %d, %v, want %d", c.beg, c.end, n, err, c.want)
+		}
+	}
+
+	estimate, err := db.EstimateRange(ctx, "/users/", "/users0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if estimate.Keys < 50 || estimate.Keys > 150 || estimate.Bytes <= 0 {
+		t.Errorf("got estimate %+v, want about 100 keys", estimate)
+	}
+
+	usage, err := snap.Usage(ctx, "/", "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PrefixUsage{
+		{Prefix: "/groups/", Keys: 50},
+		{Prefix: "/users/", Keys: 100},
+		{Prefix: "/version", Keys: 1},
+	}
+	if len(usage) != len(want) {
+		t.Fatalf("got %d prefixes, want %d", len(usage), len(want))
+	}
+	for i, u := range usage {
+		if u.Prefix != want[i].Prefix || u.Keys != want[i].Keys || u.Bytes <= 0 {
+			t.Errorf("got usage %+v, want %+v", u, want[i])
+		}
+	}
+
+	usage, err = snap.Usage(ctx, "/users/", "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 100 {
+		t.Fatalf("got %d prefixes, want 100", len(usage))
+	}
+	if u := usage[0]; u.Prefix != "/users/000/" || u.Keys != 1 {
+		t.Errorf("got usage %+v, want prefix /users/000/ with one key", u)
+	}
+}
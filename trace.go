@@ -0,0 +1,63 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// Tracer creates spans for database operations. It is a small subset of the
+// OpenTelemetry tracing API, so that adapters for OpenTelemetry or any other
+// tracing library can be written in a few lines.
+type Tracer interface {
+	// Start begins a new span with the given name and attributes. Returned
+	// context carries the new span.
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span represents a single traced operation.
+type Span interface {
+	// SetAttributes adds attributes to the span.
+	SetAttributes(attrs ...Attribute)
+
+	// End completes the span with the operation's result.
+	End(err error)
+}
+
+// Attribute is a key-value pair attached to a span.
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// Attribute keys recorded by the database operations.
+const (
+	AttrKey      = "kv.key"
+	AttrBegin    = "kv.begin"
+	AttrEnd      = "kv.end"
+	AttrSQLState = "db.response.status_code"
+)
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttributes(...Attribute) {}
+func (nopSpan) End(error)                  {}
+
+func (d *Database) startSpan(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	if d.tracer == nil {
+		return ctx, nopSpan{}
+	}
+	return d.tracer.Start(ctx, name, attrs...)
+}
+
+// endSpan records the SQLSTATE code for postgres errors and ends the span.
+func endSpan(span Span, err error) {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		span.SetAttributes(Attribute{Key: AttrSQLState, Value: string(pqErr.Code)})
+	}
+	span.End(err)
+}
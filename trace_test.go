@@ -0,0 +1,116 @@
+// Copyright (c) 2025 Visvasity LLC
+
+package kvpostgres
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testSpan struct {
+	name  string
+	attrs map[string]string
+	err   error
+}
+
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+func (v *testTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	s := &testSpan{name: name, attrs: make(map[string]string)}
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+	v.mu.Lock()
+	v.spans = append(v.spans, s)
+	v.mu.Unlock()
+	return ctx, s
+}
+
+func (s *testSpan) SetAttributes(attrs ...Attribute) {
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *testSpan) End(err error) {
+	s.err = err
+}
+
+func (v *testTracer) find(name string) *testSpan {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, s := range v.spans {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestTracer(t *testing.T) {
+	ctx := context.Background()
+
+	dbDir := filepath.Join(t.TempDir(), "database")
+	t.Log("using database dir", dbDir)
+
+	tracer := new(testTracer)
+	db, err := NewWithOptions(ctx, dbDir, &Options{Tracer: tracer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Set(ctx, "/a", strings.NewReader("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := db.NewSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Discard(ctx)
+
+	for range snap.Ascend(ctx, "/a", "/b", &err) {
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Writes in a read-only snapshot must fail with a SQLSTATE.
+	if err := snap.Set(ctx, "/b", strings.NewReader("b")); err == nil {
+		t.Fatalf("wanted non-nil error for writes in a snapshot")
+	}
+
+	for _, name := range []string{"kvpostgres.NewTransaction", "kvpostgres.Commit", "kvpostgres.NewSnapshot"} {
+		if s := tracer.find(name); s == nil || s.err != nil {
+			t.Errorf("span %s: wanted a successful span, got %+v", name, s)
+		}
+	}
+	if s := tracer.find("kvpostgres.Ascend"); s == nil || s.attrs[AttrBegin] != "/a" || s.attrs[AttrEnd] != "/b" {
+		t.Errorf("wanted ascend span with key range attributes, got %+v", s)
+	}
+	var sets []*testSpan
+	for _, s := range tracer.spans {
+		if s.name == "kvpostgres.Set" {
+			sets = append(sets, s)
+		}
+	}
+	if len(sets) != 2 {
+		t.Fatalf("wanted two set spans, got %d", len(sets))
+	}
+	if s := sets[1]; s.err == nil || s.attrs[AttrSQLState] == "" || s.attrs[AttrKey] != "/b" {
+		t.Errorf("wanted failed set span with sqlstate, got %+v", s)
+	}
+}